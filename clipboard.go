@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/almahoozi/clip/store"
+)
+
+var errNoClipboard = errors.New("no system clipboard tool found")
+
+// clipboardTools returns the commands copying to and pasting from the system
+// clipboard on this platform, in order of preference.
+func clipboardTools() (copyTools, pasteTools [][]string) {
+	switch runtime.GOOS {
+	case "darwin":
+		return [][]string{{"pbcopy"}}, [][]string{{"pbpaste"}}
+	case "windows":
+		return [][]string{{"clip.exe"}},
+			[][]string{{"powershell.exe", "-NoProfile", "-Command", "Get-Clipboard -Raw"}}
+	}
+
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		copyTools = append(copyTools, []string{"wl-copy"})
+		pasteTools = append(pasteTools, []string{"wl-paste", "--no-newline"})
+	}
+	copyTools = append(copyTools,
+		[]string{"xclip", "-selection", "clipboard", "-in"},
+		[]string{"xsel", "--clipboard", "--input"},
+	)
+	pasteTools = append(pasteTools,
+		[]string{"xclip", "-selection", "clipboard", "-out"},
+		[]string{"xsel", "--clipboard", "--output"},
+	)
+	return copyTools, pasteTools
+}
+
+// findTool returns the first of the commands that is installed.
+func findTool(commands [][]string) ([]string, error) {
+	for _, command := range commands {
+		if _, err := exec.LookPath(command[0]); err == nil {
+			return command, nil
+		}
+	}
+	return nil, errNoClipboard
+}
+
+// toClipboard copies the data to the system clipboard.
+func toClipboard(data string) error {
+	copyTools, _ := clipboardTools()
+	command, err := findTool(copyTools)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = strings.NewReader(data)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("error running %s: %w: %s", command[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// fromClipboard returns the contents of the system clipboard.
+func fromClipboard() (string, error) {
+	_, pasteTools := clipboardTools()
+	command, err := findTool(pasteTools)
+	if err != nil {
+		return "", err
+	}
+
+	var stderr strings.Builder
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("error running %s: %w: %s", command[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// watch polls the system clipboard and adds every new value to the history
+// until interrupted. The history is only opened, and so locked, while adding
+// a value so other invocations keep working in the meantime.
+func watch(config Config, flags Flags) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ticker := time.NewTicker(flags.WatchInterval)
+	defer ticker.Stop()
+
+	// Hash like the history does, so values it considers equal are debounced
+	var last, lastErr string
+	for {
+		text, err := fromClipboard()
+		if errors.Is(err, errNoClipboard) {
+			return err
+		} else if err != nil {
+			// Clipboard tools fail transiently, e.g. when the clipboard is empty
+			if err.Error() != lastErr {
+				Warnf("Failed to read the system clipboard: %v", err)
+				lastErr = err.Error()
+			}
+		} else if hash := config.Hash(text); strings.TrimSpace(text) != "" && hash != last {
+			// The history can be locked for a while, e.g. during --edit, so
+			// retry on the next tick instead of giving up
+			app, err := openApplication(config)
+			if err != nil {
+				if err.Error() != lastErr {
+					Warnf("%v, retrying", err)
+					lastErr = err.Error()
+				}
+			} else if err := record(app, text, flags); err != nil {
+				return err
+			} else {
+				last, lastErr = hash, ""
+				if !flags.Silent {
+					Outln(escape(text))
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// record adds a value of the system clipboard to the opened history and
+// closes it. A read-only history fails, rather than dropping the value.
+func record(app *application, text string, flags Flags) error {
+	if app.ReadOnly() {
+		if err := app.Close(); err != nil {
+			Errorf("%v", err)
+		}
+		return withCode(codeStorage, store.ErrReadOnly)
+	}
+
+	before := app.Len()
+	item, err := app.Add(text)
+	app.logChange(OpWatch, before, err)
+	if err != nil {
+		Warnf("Skipping the system clipboard: %v", err)
+	} else if item.Tag(flags.Tags...) {
+		app.Touch()
+	}
+	if err := app.Close(); err != nil {
+		return withCode(codeStorage, err)
+	}
+	return nil
+}
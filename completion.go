@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// completionIndexFlags take the index of an item, which the completion
+// scripts complete from `clip --count`.
+var completionIndexFlags = []string{"paste", "delete", "pin", "edit", "move", "range", "promote"}
+
+// completionFileFlags take a path.
+var completionFileFlags = []string{"file", "import"}
+
+// completion returns the completion script for the shell, built from the
+// visible flags.
+func completion(shell string, flagset *pflag.FlagSet) (string, error) {
+	var flags []*pflag.Flag
+	flagset.VisitAll(func(flag *pflag.Flag) {
+		if !flag.Hidden {
+			flags = append(flags, flag)
+		}
+	})
+
+	switch shell {
+	case "bash":
+		return bashCompletion(flags), nil
+	case "zsh":
+		return zshCompletion(flags), nil
+	case "fish":
+		return fishCompletion(flags), nil
+	default:
+		return "", fmt.Errorf("unsupported shell %q, expected bash, zsh or fish", shell)
+	}
+}
+
+// summary shortens the usage of a flag to its first clause.
+func summary(usage string) string {
+	if i := strings.IndexAny(usage, ";,("); i > 0 {
+		usage = usage[:i]
+	}
+	return strings.TrimSpace(usage)
+}
+
+// takesValue reports whether the flag must be followed by a value, and
+// whether the value is optional.
+func takesValue(flag *pflag.Flag) (bool, bool) {
+	if flag.Value.Type() == "bool" {
+		return false, false
+	}
+	return true, flag.NoOptDefVal != ""
+}
+
+func bashCompletion(flags []*pflag.Flag) string {
+	var words, indexFlags, fileFlags []string
+	for _, flag := range flags {
+		names := []string{"--" + flag.Name}
+		if flag.Shorthand != "" {
+			names = append(names, "-"+flag.Shorthand)
+		}
+		words = append(words, names...)
+		if slices.Contains(completionIndexFlags, flag.Name) {
+			indexFlags = append(indexFlags, names...)
+		} else if slices.Contains(completionFileFlags, flag.Name) {
+			fileFlags = append(fileFlags, names...)
+		}
+	}
+
+	return fmt.Sprintf(`# bash completion for clip, load it with: source <(clip --completion=bash)
+_clip_indices() {
+    local n
+    n=$(clip --count 2>/dev/null) || return
+    [ "$n" -gt 0 ] && seq 0 $((n - 1))
+}
+
+_clip() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+    case "$prev" in
+        %s)
+            COMPREPLY=($(compgen -W "$(_clip_indices)" -- "$cur"))
+            return
+            ;;
+        %s)
+            COMPREPLY=($(compgen -f -- "$cur"))
+            return
+            ;;
+        --ns)
+            COMPREPLY=($(compgen -W "$(clip --namespaces 2>/dev/null)" -- "$cur"))
+            return
+            ;;
+    esac
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "%s" -- "$cur"))
+    fi
+}
+
+complete -o default -F _clip clip
+`, strings.Join(indexFlags, "|"), strings.Join(fileFlags, "|"), strings.Join(words, " "))
+}
+
+func zshCompletion(flags []*pflag.Flag) string {
+	escape := strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`)
+
+	var specs []string
+	for _, flag := range flags {
+		spec := "--" + flag.Name
+		if flag.Shorthand != "" {
+			spec = fmt.Sprintf("(-%s --%s)'{-%s,--%s}'", flag.Shorthand, flag.Name, flag.Shorthand, flag.Name)
+		}
+		spec += "[" + escape.Replace(summary(flag.Usage)) + "]"
+
+		if value, optional := takesValue(flag); value {
+			if optional {
+				spec += ":"
+			}
+			switch {
+			case slices.Contains(completionIndexFlags, flag.Name):
+				spec += ":index:_clip_indices"
+			case slices.Contains(completionFileFlags, flag.Name):
+				spec += ":file:_files"
+			case flag.Name == "ns":
+				spec += ":namespace:_clip_namespaces"
+			default:
+				spec += ":" + flag.Value.Type() + ": "
+			}
+		}
+		specs = append(specs, "'"+spec+"'")
+	}
+
+	return fmt.Sprintf(`#compdef clip
+# zsh completion for clip, save it as _clip somewhere in $fpath
+
+_clip_indices() {
+    local n
+    n=$(clip --count 2>/dev/null) || return
+    (( n > 0 )) && compadd -- $(seq 0 $((n - 1)))
+}
+
+_clip_namespaces() {
+    compadd -- $(clip --namespaces 2>/dev/null)
+}
+
+_arguments -s \
+    %s \
+    '*:text: '
+`, strings.Join(specs, " \\\n    "))
+}
+
+func fishCompletion(flags []*pflag.Flag) string {
+	escape := strings.NewReplacer(`\`, `\\`, "'", `\'`)
+
+	var lines []string
+	for _, flag := range flags {
+		line := "complete -c clip"
+		if flag.Shorthand != "" {
+			line += " -s " + flag.Shorthand
+		}
+		line += " -l " + flag.Name + " -d '" + escape.Replace(summary(flag.Usage)) + "'"
+
+		if value, optional := takesValue(flag); value {
+			if !optional {
+				line += " -r"
+			}
+			switch {
+			case slices.Contains(completionIndexFlags, flag.Name):
+				line += " -f -a '(__clip_indices)'"
+			case slices.Contains(completionFileFlags, flag.Name):
+				line += " -F"
+			case flag.Name == "ns":
+				line += " -f -a '(clip --namespaces 2>/dev/null)'"
+			}
+		}
+		lines = append(lines, line)
+	}
+
+	return fmt.Sprintf(`# fish completion for clip, load it with: clip --completion=fish | source
+function __clip_indices
+    set -l n (clip --count 2>/dev/null); or return
+    test "$n" -gt 0; and seq 0 (math $n - 1)
+end
+
+%s
+`, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/pflag"
+)
+
+// errorCode classifies failures for scripts, see --json-errors.
+type errorCode string
+
+const (
+	codeError      errorCode = "error"
+	codeUsage      errorCode = "usage"
+	codeOutOfRange errorCode = "out_of_range"
+	codePipeInput  errorCode = "pipe_input"
+	codeStorage    errorCode = "storage"
+)
+
+// exitStatus is what clip exits with when failing with the code.
+func (code errorCode) exitStatus() int {
+	switch code {
+	case codeUsage, codePipeInput:
+		return 2
+	case codeOutOfRange:
+		return 3
+	case codeStorage:
+		return 4
+	default:
+		return 1
+	}
+}
+
+// codedError attaches an error code to an error.
+type codedError struct {
+	code errorCode
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// withCode classifies the error, keeping it nil if it is.
+func withCode(code errorCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{code: code, err: err}
+}
+
+// usageErrorf is a usage error, i.e. invalid flags or arguments.
+func usageErrorf(format string, args ...any) error {
+	return withCode(codeUsage, fmt.Errorf(format, args...))
+}
+
+// codeOf returns the code the error was classified with.
+func codeOf(err error) errorCode {
+	var coded *codedError
+	switch {
+	case errors.As(err, &coded):
+		return coded.code
+	case errors.Is(err, pflag.ErrHelp):
+		return codeUsage
+	case errors.Is(err, errEmpty):
+		return codeOutOfRange
+	default:
+		return codeError
+	}
+}
+
+// jsonErrors is set from --json-errors once the flags are parsed.
+var jsonErrors = false
+
+// fail reports the error and exits with the status of its code. Usage errors
+// are followed by the usage, unless reported as JSON.
+func fail(err error) {
+	code := codeOf(err)
+	if jsonErrors {
+		data, _ := json.Marshal(struct {
+			Code    errorCode `json:"code"`
+			Message string    `json:"message"`
+		}{code, err.Error()})
+		fmt.Fprintln(os.Stderr, string(data))
+		os.Exit(code.exitStatus())
+	}
+
+	// The usage error itself is only a hint that the flags are wrong
+	if !errors.Is(err, pflag.ErrHelp) {
+		Errorf("%v", err)
+	}
+	if code == codeUsage {
+		pflag.Usage()
+	}
+	os.Exit(code.exitStatus())
+}
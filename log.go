@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+)
+
+// Verbosity controls which log lines are written to stderr.
+type Verbosity int
+
+const (
+	// VerbosityQuiet only logs errors.
+	VerbosityQuiet Verbosity = iota
+	// VerbosityNormal also logs warnings, problems clip recovers from.
+	VerbosityNormal
+	// VerbosityVerbose also logs debug lines about what clip is doing.
+	VerbosityVerbose
+)
+
+// verbosity is set from --quiet and --verbose once the flags are parsed.
+var verbosity = VerbosityNormal
+
+// Fatalf fails with a storage error, for when the history can't be loaded.
+func Fatalf(format string, args ...any) {
+	fail(withCode(codeStorage, fmt.Errorf(format, args...)))
+}
+
+// Errorf logs an error, regardless of the verbosity.
+func Errorf(format string, args ...any) {
+	log.Printf(format, args...)
+}
+
+// Warnf logs a problem clip recovers from, unless quiet.
+func Warnf(format string, args ...any) {
+	if verbosity >= VerbosityNormal {
+		log.Printf(format, args...)
+	}
+}
+
+// Debugf logs what clip is doing, only when verbose.
+func Debugf(format string, args ...any) {
+	if verbosity >= VerbosityVerbose {
+		log.Printf("debug: "+format, args...)
+	}
+}
+
+// logger passes the store's log lines on to Warnf and Debugf.
+type logger struct{}
+
+func (logger) Warnf(format string, args ...any)  { Warnf(format, args...) }
+func (logger) Debugf(format string, args ...any) { Debugf(format, args...) }
+
+// historyLog appends structured lines about opening, changing and saving the
+// history to CLIP_LOG, to diagnose lost changes. It is nil unless configured,
+// and then nothing is logged.
+var historyLog *slog.Logger
+
+// openHistoryLog starts appending to the history log at the path. The file is
+// left for the process exit to close, since every line is written directly.
+func openHistoryLog(path string) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		Warnf("Not logging to CLIP_LOG: %v", err)
+		return
+	}
+	historyLog = slog.New(slog.NewTextHandler(file, nil)).With("pid", os.Getpid())
+}
+
+// logHistory appends a line to the history log, if there is one.
+func logHistory(msg string, args ...any) {
+	if historyLog != nil {
+		historyLog.Info(msg, args...)
+	}
+}
@@ -1,189 +1,445 @@
 package main
 
 import (
-	"crypto/sha1"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/almahoozi/clip/store"
 	"github.com/spf13/pflag"
 )
 
-var version = "v0.0.0"
-
-// TODO: Useful to implement
+// Build metadata, set with -ldflags "-X main.commit=..." by the makefile.
+var (
+	version = "v0.0.0"
+	commit  = "unknown"
+	ref     = "unknown"
+	date    = "unknown"
+)
 
-type RingBuffer[T any] struct {
-	Size  int
-	Items []T
-	Start int
-	End   int
+// buildInfo is the version output, see --version.
+type buildInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	Ref     string `json:"ref"`
+	Date    string `json:"date"`
+	Go      string `json:"go"`
 }
 
-var _ = RingBuffer[int]{}
+// build returns the build metadata, falling back to what the Go toolchain
+// recorded, e.g. when installed with `go install`.
+func build() buildInfo {
+	info := buildInfo{Version: version, Commit: commit, Ref: ref, Date: date, Go: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "unknown":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.Date == "unknown":
+				info.Date = setting.Value
+			}
+		}
+	}
+	return info
+}
 
 type application struct {
-	filePath string
-	Items    []*Item `json:"i,omitempty"`
-	index    map[string]int
+	*store.Store
+	config Config
 }
 
+// NewApplication opens the history of the configured namespace, failing with
+// a storage error if it can't be loaded.
 func NewApplication(config Config) *application {
-	// Load the items from the file, which will be in the standard location:
-	// - On Linux: $XDG_DATA_HOME/clip
-	// - On macOS: $HOME/Library/Application Support/clip
-	// - On Windows: %APPDATA%/clip
-
-	filePath := os.Getenv("XDG_DATA_HOME")
-	if filePath == "" {
-		filePath = os.Getenv("HOME") + "/.local/share"
+	app, err := openApplication(config)
+	switch {
+	case errors.Is(err, store.ErrNoKey):
+		Fatalf("Failed to load data file: the data file is encrypted, set CLIP_KEY to decrypt it")
+	case errors.Is(err, store.ErrBadKey):
+		Fatalf("Failed to load data file: failed to decrypt the data file, is CLIP_KEY correct?")
+	case err != nil:
+		Fatalf("%v", err)
 	}
+	return app
+}
 
-	filePath += "/clip"
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		// Create the directory if it does not exist
-		if err := os.MkdirAll(filePath, 0o755); err != nil {
-			log.Fatalf("Failed to create directory: %v", err)
-		}
+// openApplication opens the history of the configured namespace, for the
+// long running operations that have to survive it failing to load, e.g.
+// while another process holds the lock.
+func openApplication(config Config) (*application, error) {
+	// Load the items from the file, which will be in the standard location
+	// unless configured otherwise
+	filePath, err := dataFile(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve data file path: %w", err)
 	}
+	Debugf("Using data file %s", filePath)
 
-	filePath += "/data.json"
-	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0o644)
+	s, err := store.Open(filePath, config.Config)
 	if err != nil {
-		log.Fatalf("Failed to open file: %v", err)
+		logHistory("open", "path", filePath, "error", err)
+		return nil, fmt.Errorf("failed to load data file: %w", err)
 	}
-	defer func() {
-		if err := file.Close(); err != nil {
-			log.Printf("Failed to close file: %v", err)
-		}
-	}()
+	logHistory("open", "path", filePath, "items", s.Len(), "read_only", s.ReadOnly())
+	return &application{Store: s, config: config}, nil
+}
+
+// Close saves the history like Store.Close, logging it to the history log.
+func (app *application) Close() error {
+	items := app.Len()
+	err := app.Store.Close()
+	logHistory("close", "path", app.Path(), "items", items, "error", err)
+	return err
+}
+
+// logChange logs a change to the history log, with the number of items before
+// and after it.
+func (app *application) logChange(op Op, before int, err error) {
+	logHistory("change", "op", op, "before", before, "after", app.Len(), "error", err)
+}
+
+// defaultNamespace is the namespace stored in the data file itself.
+const defaultNamespace = "default"
+
+// validNamespace matches namespace names, which become part of a file name.
+var validNamespace = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// baseFile returns the absolute path of the data file of the default
+// namespace.
+func baseFile(config Config) (string, error) {
+	filePath := config.DataFile
+	if filePath == "" {
+		filePath = filepath.Join(dataDir(), "data.json")
+	}
+	return filepath.Abs(filePath)
+}
 
-	var app application
-	if err := json.NewDecoder(file).Decode(&app); err != nil && err.Error() != "EOF" {
-		log.Fatalf("Failed to decode JSON: %v", err)
+// dataFile returns the absolute path of the data file of the configured
+// namespace. Other namespaces live next to the default one, e.g. the "code"
+// namespace of data.json is stored in data-code.json.
+func dataFile(config Config) (string, error) {
+	filePath, err := baseFile(config)
+	if err != nil {
+		return "", err
+	}
+	if config.Namespace == "" || config.Namespace == defaultNamespace {
+		return filePath, nil
+	}
+	if !validNamespace.MatchString(config.Namespace) {
+		return "", fmt.Errorf("invalid namespace %q", config.Namespace)
 	}
-	app.filePath = filePath
-	app.Reindex()
 
-	return &app
+	ext := filepath.Ext(filePath)
+	return strings.TrimSuffix(filePath, ext) + "-" + config.Namespace + ext, nil
 }
 
-func (app *application) Close() error {
-	file, err := os.OpenFile(app.filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+// namespaces returns the namespaces that have a data file.
+func namespaces(config Config) ([]string, error) {
+	filePath, err := baseFile(config)
 	if err != nil {
-		log.Printf("Failed to open file for writing: %v", err)
-		return err
+		return nil, err
 	}
-	defer func() {
-		if err := file.Close(); err != nil {
-			log.Printf("Failed to close file: %v", err)
-		}
-	}()
 
-	if err := json.NewEncoder(file).Encode(app); err != nil {
-		log.Printf("Failed to encode JSON: %v", err)
-		return err
+	var names []string
+	if _, err := os.Stat(filePath); err == nil {
+		names = append(names, defaultNamespace)
 	}
 
-	if err := file.Sync(); err != nil {
-		log.Printf("Failed to sync file: %v", err)
-		return err
+	entries, err := os.ReadDir(filepath.Dir(filePath))
+	if errors.Is(err, os.ErrNotExist) {
+		return names, nil
+	} else if err != nil {
+		return nil, err
 	}
 
-	return nil
+	base := filepath.Base(filePath)
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if name, ok = strings.CutSuffix(name, ext); ok && validNamespace.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
 }
 
-type Config struct{}
+// dataDir returns the platform specific directory the history is stored in:
+// - On Linux: $XDG_DATA_HOME/clip, or $HOME/.local/share/clip if unset
+// - On macOS: $HOME/Library/Application Support/clip
+// - On Windows: %APPDATA%/clip
+func dataDir() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return filepath.Join(os.Getenv("HOME"), "Library", "Application Support", "clip")
+	case "windows":
+		return filepath.Join(os.Getenv("APPDATA"), "clip")
+	}
 
-type Item struct {
-	Data string `json:"d,omitempty"`
-	Hash string `json:"h,omitempty"`
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".local", "share")
+	}
+	return filepath.Join(dir, "clip")
 }
 
-func (app *application) hash(data string) string {
-	data = strings.TrimSpace(data)
-	hash := sha1.Sum([]byte(data))
-	return base64.RawURLEncoding.EncodeToString(hash[:])
+// Config is the configuration of the store, plus what only the CLI uses.
+type Config struct {
+	store.Config
+	// ReorderOnPaste moves pasted items to the front of the history.
+	ReorderOnPaste bool
+	// ServeToken is the bearer token --serve requires on every request.
+	ServeToken string
+	// DataFile overrides the platform specific data file path, if set.
+	DataFile string
+	// Namespace selects a separate history stored next to the data file.
+	Namespace string
+	// StdinTimeout is how long to wait for piped input, 0 to wait until the
+	// pipe is closed.
+	StdinTimeout time.Duration
+	// LogFile is where to append the history log, if set.
+	LogFile string
 }
 
-func (app *application) Add(data string) {
-	hash := app.hash(data)
-
-	if idx, exists := app.index[hash]; exists && idx == len(app.Items)-1 {
-		// Item already exists and is the latest, do nothing
-		return
-	} else if exists {
-		// Remove it and re-add it to the end
-		app.Remove(idx)
+// LoadConfig reads the configuration from the environment:
+// - CLIP_MAX_ITEMS: the maximum number of items kept in the history
+// - CLIP_MAX_ITEM_BYTES: the maximum size of an item, after trimming
+// - CLIP_MAX_TOTAL_BYTES: the maximum combined size of the items
+// - CLIP_REORDER_ON_PASTE: whether pasting moves the item to the front
+// - CLIP_TTL: how long items are kept, e.g. 12h or 30d
+// - CLIP_CASE_INSENSITIVE: whether deduplication ignores case
+// - CLIP_TRIM: whether deduplication ignores surrounding whitespace
+// - CLIP_NORMALIZE_NEWLINES: whether deduplication treats \r\n as \n
+// - CLIP_DETECT_SECRETS: whether items that look like credentials are marked
+// sensitive
+// - CLIP_SENSITIVE_TTL: how long sensitive items are kept
+// - CLIP_ALLOW_DUPLICATES: whether re-added text is kept as a new item
+// - CLIP_COMPRESS: whether the data file is written gzip compressed
+// - CLIP_KEY: the passphrase to encrypt the data file with
+// - CLIP_SERVE_TOKEN: the token --serve requires
+// - CLIP_STDIN_TIMEOUT: how long to wait for piped input
+// - CLIP_LOG: the file to append the history log to
+// - CLIP_DATA_FILE: the path of the data file
+// - CLIP_FILE_MODE, CLIP_DIR_MODE: the octal permissions of the data file and
+// its directory, e.g. 600 and 700
+func LoadConfig() Config {
+	return Config{
+		Config: store.Config{
+			MaxItems:          envInt("CLIP_MAX_ITEMS", 0),
+			MaxItemBytes:      envInt("CLIP_MAX_ITEM_BYTES", 0),
+			MaxTotalBytes:     envInt("CLIP_MAX_TOTAL_BYTES", 0),
+			TTL:               envDuration("CLIP_TTL", 0),
+			CaseInsensitive:   envBool("CLIP_CASE_INSENSITIVE", false),
+			DetectSecrets:     envBool("CLIP_DETECT_SECRETS", false),
+			SensitiveTTL:      envDuration("CLIP_SENSITIVE_TTL", 0),
+			Trim:              envBool("CLIP_TRIM", true),
+			NormalizeNewlines: envBool("CLIP_NORMALIZE_NEWLINES", true),
+			AllowDuplicates:   envBool("CLIP_ALLOW_DUPLICATES", false),
+			Compress:          envBool("CLIP_COMPRESS", false),
+			Key:               os.Getenv("CLIP_KEY"),
+			FileMode:          envMode("CLIP_FILE_MODE", 0o644),
+			DirMode:           envMode("CLIP_DIR_MODE", 0o755),
+			Logger:            logger{},
+		},
+		ReorderOnPaste: envBool("CLIP_REORDER_ON_PASTE", true),
+		ServeToken:     os.Getenv("CLIP_SERVE_TOKEN"),
+		DataFile:       os.Getenv("CLIP_DATA_FILE"),
+		StdinTimeout:   envDuration("CLIP_STDIN_TIMEOUT", 0),
+		LogFile:        os.Getenv("CLIP_LOG"),
 	}
-
-	app.Items = append(app.Items, &Item{data, hash})
-	app.index[hash] = len(app.Items) - 1
 }
 
-func (app *application) Get(index int) *Item {
-	if index < 0 || index >= len(app.Items) {
-		return nil
+// envInt reads a non-negative integer from the environment, falling back to
+// def if it is unset or invalid.
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
 	}
-	return app.Items[index]
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		Warnf("Ignoring invalid %s %q", name, v)
+		return def
+	}
+	return n
 }
 
-func (app *application) Clear() {
-	app.Items = nil
-	app.index = make(map[string]int) // Reset index when deleting all items
-}
+// envDuration reads a non-negative duration from the environment, falling
+// back to def if it is unset or invalid. On top of the units supported by
+// time.ParseDuration a "d" suffix can be used for days.
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
 
-func (app *application) Reindex() {
-	app.index = make(map[string]int)
-	for i, item := range app.Items {
-		app.index[item.Hash] = i
+	var d time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(v)
 	}
+	if err != nil || d < 0 {
+		Warnf("Ignoring invalid %s %q", name, v)
+		return def
+	}
+	return d
 }
 
-func (app *application) Remove(idx int) {
-	if idx < 0 || idx >= len(app.Items) {
-		return
+// envMode reads octal permissions from the environment, e.g. 600 or 0600,
+// falling back to def if it is unset or invalid.
+func envMode(name string, def os.FileMode) os.FileMode {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
 	}
+	n, err := strconv.ParseUint(strings.TrimPrefix(v, "0o"), 8, 32)
+	if err != nil || n > 0o777 {
+		Warnf("Ignoring invalid %s %q", name, v)
+		return def
+	}
+	return os.FileMode(n)
+}
 
-	if idx == 0 && len(app.Items) == 1 {
-		app.Items = nil
-		app.index = make(map[string]int) // Reset index if the last item is removed
-		return
+// envBool reads a boolean from the environment, falling back to def if it is
+// unset or invalid.
+func envBool(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		Warnf("Ignoring invalid %s %q", name, v)
+		return def
 	}
+	return b
+}
 
-	defer app.Reindex()
+// preview prints the items that would be deleted, as numbered list lines, and
+// discards any change so nothing is written.
+func (app *application) preview(indices []int) {
+	for _, i := range indices {
+		item := app.Get(i)
+		line := escape(item.Data)
+		if item.Binary {
+			line = binaryLabel
+		}
+		Outf("%d\t%s\n", app.Len()-i-1, line)
+	}
+	app.Discard()
+}
 
-	if idx == 0 {
-		app.Items = app.Items[1:]
-		return
+// pasteRange pastes the items from the first to the last index of the range,
+// in that order, without reordering the history.
+func (app *application) pasteRange(flags Flags) error {
+	from, err := resolveIdx(flags.PasteIndex, app.Len())
+	if err != nil {
+		return err
 	}
-	if idx == len(app.Items)-1 {
-		app.Items = app.Items[:len(app.Items)-1]
-		return
+	to, err := resolveIdx(flags.PasteEnd, app.Len())
+	if err != nil {
+		return err
 	}
-	app.Items = append(app.Items[:idx], app.Items[idx+1:]...)
-}
 
-func (app *application) List() []*Item {
-	return app.Items
+	step := 1
+	if to < from {
+		step = -1
+	}
+	// Ranges are never reordered, so this is a pure read
+	var items []*store.Item
+	for i := from; ; i += step {
+		items = append(items, app.Get(i))
+		if i == to {
+			break
+		}
+	}
+	return outputAll(items, flags)
 }
 
 type Flags struct {
 	Operation Op
 	Text      string // Positional argument for text input
 	Silent    bool   // Flag to indicate if the text should be echoed back
-	// FIX: We can't support negative indices in the flags directly, consider -P
-	// for pasting negative index. We can't use this for deletes as it takes a
-	// slice which can have mixed signs.
+	Confirm   bool   // Echo where the text was added instead of the text
+	// NOTE: Negative indices passed as separate arguments, e.g. `-p -1`, are
+	// folded into their flag by normalizeArgs before parsing.
 	PasteIndex    int
-	DeleteIndices []int  // Slice of integers for delete indices
-	ListArgs      [2]int // Range for listing items, first and last index
+	PasteIndexSet bool          // Whether PasteIndex was passed explicitly, even if 0
+	PasteEnd      int           // Last index of the range to paste, if PasteRange
+	PasteRange    bool          // Paste the items from PasteIndex to PasteEnd
+	Separator     string        // Joins the items of a pasted range
+	DeleteIndices []int         // Slice of integers for delete indices
+	DeleteRange   bool          // Delete the items from the first to the last of DeleteIndices
+	ListArgs      [2]int        // Range for listing items, first and last index
+	Query         string        // Substring to search for in the items
+	Regex         bool          // Interpret the query as a regular expression
+	IgnoreCase    bool          // Match the query regardless of case
+	JSON          bool          // Output the list as JSON
+	Null          bool          // Separate list items with NUL instead of newlines
+	Numbered      bool          // Prefix list items with their index
+	Size          bool          // Prefix list items with their size in bytes
+	UniquePrefix  int           // List only the newest item of those sharing this many leading characters
+	HashPrefix    string        // List only the items whose hash starts with it, along with their hash
+	Chronological bool          // List the oldest items first
+	Width         int           // Columns to truncate list items to, 0 to never truncate
+	Color         bool          // Color the list output
+	PipeInput     string        // List output piped back to select the item to paste
+	PinIndex      int           // Index of the item to pin or unpin
+	PromoteIndex  int           // Index of the item to make the latest
+	TrimCount     int           // Number of latest unpinned items to keep
+	EditIndex     int           // Index of the item to edit
+	MoveArgs      [2]int        // Index of the item to move and where to move it
+	File          string        // Data file to use instead of the default one
+	Raw           bool          // Keep whitespace significant, overriding Config.Trim
+	Lines         bool          // Add every line of the text as a separate item
+	Texts         []string      // Items to add from a JSON array, newest first
+	Base64        bool          // Add base64 input as a binary item, or paste the item base64 encoded
+	Sensitive     bool          // Mark the added item as sensitive
+	Source        string        // Where the added item came from
+	ShowSensitive bool          // List sensitive items too
+	Namespace     string        // Namespace of the history to use
+	ImportData    []byte        // Export to merge into the history
+	ToClipboard   bool          // Also copy the pasted item to the system clipboard
+	FromClipboard bool          // Add the contents of the system clipboard
+	ToTmux        bool          // Also load the pasted item into a tmux buffer
+	Out           string        // File to write the pasted item to instead of stdout
+	Template      string        // Wraps the pasted item, which replaces its only %s
+	WithHash      bool          // Output the hash of the pasted item on the line before it
+	Strip         string        // Characters, or with StripRegex an expression, to trim from both ends of the pasted item
+	StripRegex    bool          // Interpret Strip as a regular expression
+	FromTmux      bool          // Add the contents of the top tmux buffer
+	WatchInterval time.Duration // How often to poll the system clipboard
+	Tags          []string      // Tags to add to the item, or to filter the list by
+	Newline       bool          // Append a newline to the pasted item
+	Peek          bool          // Paste without moving the item to the front
+	Oldest        bool          // Paste, pop or delete the oldest item instead
+	Match         string        // Substring selecting the items to delete
+	Hashes        []string      // Hashes of the items to delete
+	DryRun        bool          // Print the items that would be deleted instead
+	GetHash       string        // Hash, or unique hash prefix, of the item to output
+	Shell         string        // Shell to print the completion script for
+	Addr          string        // Address to serve the history on
 }
 
 type Op int
@@ -196,8 +452,85 @@ const (
 	OpDelete
 	OpDeleteAll
 	OpList
+	OpFind
+	OpPin
+	OpPop
+	OpEdit
+	OpDedup
+	OpNamespaces
+	OpExport
+	OpImport
+	OpWatch
+	OpStats
+	OpCount
+	OpDuplicates
+	OpOpen
+	OpPromote
+	OpTrim
+	OpMove
+	OpUndo
+	OpGetHash
+	OpFirst
+	OpLast
+	OpCompletion
+	OpWhere
+	OpServe
+	OpPick
 )
 
+// opNames are the names operations are logged by.
+var opNames = [...]string{
+	OpHelp:       "help",
+	OpVersion:    "version",
+	OpAdd:        "add",
+	OpPaste:      "paste",
+	OpDelete:     "delete",
+	OpDeleteAll:  "delete-all",
+	OpList:       "list",
+	OpFind:       "find",
+	OpPin:        "pin",
+	OpPop:        "pop",
+	OpEdit:       "edit",
+	OpDedup:      "dedup",
+	OpNamespaces: "namespaces",
+	OpExport:     "export",
+	OpImport:     "import",
+	OpWatch:      "watch",
+	OpStats:      "stats",
+	OpCount:      "count",
+	OpDuplicates: "duplicates",
+	OpOpen:       "open",
+	OpPromote:    "promote",
+	OpTrim:       "trim",
+	OpMove:       "move",
+	OpUndo:       "undo",
+	OpGetHash:    "get-hash",
+	OpFirst:      "first",
+	OpLast:       "last",
+	OpCompletion: "completion",
+	OpWhere:      "where",
+	OpServe:      "serve",
+	OpPick:       "pick",
+}
+
+func (op Op) String() string {
+	if op >= 0 && int(op) < len(opNames) {
+		return opNames[op]
+	}
+	return fmt.Sprintf("Op(%d)", int(op))
+}
+
+// mutates reports whether the operation always changes the history, so it is
+// refused up front when the history is read-only. Others, like pasting or
+// picking, may change it too, which the store's Revision tells after the fact.
+func (op Op) mutates() bool {
+	switch op {
+	case OpAdd, OpDelete, OpDeleteAll, OpPin, OpPop, OpEdit, OpOpen, OpPromote, OpTrim, OpDedup, OpImport, OpMove, OpUndo, OpWatch:
+		return true
+	}
+	return false
+}
+
 func main() {
 	pflag.Usage = func() {
 		// Output to stderr
@@ -214,249 +547,1860 @@ func main() {
 		fmt.Fprintln(os.Stderr, "  clip -p=1              	# Pastes the item at index 1 from the clipboard")
 		fmt.Fprintln(os.Stderr, "  clip -d=2	              # Deletes the item at index 2 from the clipboard")
 		fmt.Fprintln(os.Stderr, "  clip -D                # Deletes all items from the clipboard")
+		fmt.Fprintln(os.Stderr, "  clip -f=foo            # Lists the items containing 'foo'")
 		fmt.Fprintln(os.Stderr, "  clip -v                # Prints version information")
 	}
 
 	pflag.CommandLine.SortFlags = true
 	pflag.BoolP("silent", "s", false, "Do not echo the text back to stdout after adding it to the clipboard")
-	pflag.IntP("paste", "p", 0, "Paste the nth item from the clipboard; if n is not provided, paste the last item, negative values are interpreted as offsets from the end")
-	pflag.IntSliceP("delete", "d", []int{0}, "Delete items from the clipboard; if n is not provided, delete the latest item, if multiple items are present delete them, negative values are interpreted as offsets from the end")
-	pflag.BoolP("delete-all", "D", false, "Delete all items from the clipboard")
-	pflag.IntSliceP("list", "l", []int{0, 0}, "List items in the clipboard; if no arguments are provided, list all items, if a single argument is provided [limit] it is used as a limit. If two arguments are provided [start] [end], they are used as the range to list items")
-	pflag.BoolP("version", "v", false, "Print version information")
+	pflag.VarP(&indexSlice{values: []int{0}}, "paste", "p", "Paste the nth item from the clipboard; if n is not provided, paste the last item, negative values are interpreted as offsets from the end. If two indices are provided [first] [last], paste the items in between joined by --separator")
+	pflag.String("separator", "\n", "Separator between the items pasted with a range")
+	pflag.String("add-file", "", "Add the contents of the given file; use - to read it from stdin")
+	pflag.Bool("add-json", false, "Add every string of a JSON array read from stdin as a separate item, the first string becoming the latest")
+	pflag.Bool("base64", false, "Decode the added text from base64 and store it as a binary item, or paste the item base64 encoded")
+	pflag.Int("unique-prefix", 0, "List only the latest of the items sharing their first n characters, 0 lists every item")
+	pflag.Bool("chronological", false, "List the oldest items first, the indices still count from the latest item")
+	pflag.String("completion", "", "Print the completion script for bash, zsh or fish")
+	pflag.Bool("confirm", false, "Print the index the text was added at, e.g. added (#0), instead of echoing the text back")
+	pflag.Bool("count", false, "Print the number of items in the history")
+	pflag.Bool("dedup", false, "Rehash every item and remove the older duplicates")
+	pflag.VarP(&indexSlice{values: []int{0}}, "delete", "d", "Delete items from the clipboard; if n is not provided, delete the latest item, if multiple items are present delete them, negative values are interpreted as offsets from the end")
+	pflag.BoolP("delete-all", "D", false, "Delete all items from the clipboard, except the pinned ones")
+	pflag.IntSliceP("list", "l", []int{0, 0}, "List items in the clipboard; if no arguments are provided, list all items, if a single argument is provided [limit] it is used as a limit. If two arguments are provided [start] [end], they are used as the range to list items, end is exclusive and 0 means no upper bound")
+	pflag.Int("edit", 0, "Edit the nth item in $EDITOR; if n is not provided, edit the latest item")
+	pflag.Bool("duplicates", false, "List the groups of items sharing a hash, each with the hash and the indices of its items, without changing anything; as JSON with --json")
+	pflag.Bool("dry-run", false, "Print the items --delete, --delete-all or --trim would delete, newest first, without deleting them")
+	pflag.Bool("export", false, "Print the whole history as JSON, including all metadata, to back it up or move it")
+	pflag.String("file", "", "Use the given data file instead of the default one, relative paths resolve against the current directory")
+	pflag.Bool("from-clipboard", false, "Add the contents of the system clipboard, using pbpaste, wl-paste, xclip, xsel or PowerShell")
+	pflag.Bool("from-tmux", false, "Add the contents of the top tmux buffer")
+	pflag.StringP("find", "f", "", "List the items containing the given text, newest first; can be combined with --list to narrow it down")
+	pflag.String("get-hash", "", "Output the item with the given hash, or unique hash prefix, without reordering the history")
+	pflag.Bool("first", false, "Output the oldest item without reordering the history")
+	pflag.Bool("last", false, "Output the latest item without reordering the history")
+	pflag.String("hash-prefix", "", "List only the items whose hash starts with the given prefix, prefixed with their full hash")
+	pflag.StringSlice("hash", nil, "Delete the items with the given hashes instead of deleting by index")
+	pflag.Bool("json-errors", false, "Report failures as a JSON object with a code and a message on stderr, instead of a log line and the usage")
+	pflag.Bool("json", false, "Output the list as a JSON array of objects with the index, data and hash of each item")
+	pflag.Bool("no-color", false, "Never color the list output, which is otherwise colored on a terminal unless NO_COLOR is set")
+	pflag.BoolP("null", "0", false, "Separate listed items with a NUL character and output them verbatim, without escaping newlines")
+	pflag.String("match", "", "Delete every item containing the given text instead of deleting by index, or paste the latest item containing it without reordering")
+	pflag.Bool("lines", false, "Add every non-empty line of the text as a separate item, the last line becoming the latest")
+	pflag.IntSlice("move", nil, "Move the item at the first index to the second index, e.g. --move 3 0 makes the 4th latest item the latest, without pasting it")
+	pflag.Bool("namespaces", false, "List the namespaces that have a history")
+	pflag.Bool("where", false, "Print the path of the data file, without creating it")
+	pflag.BoolP("ignore-case", "i", false, "Match the find or match text regardless of case")
+	pflag.String("import", "", "Merge an export into the history, deduplicating the items; use - to read it from stdin")
+	pflag.BoolP("newline", "n", false, "Append a newline to the pasted item")
+	pflag.String("ns", defaultNamespace, "Use a separate history for the given namespace")
+	pflag.Bool("open", false, "Open the data file in $EDITOR for bulk edits, holding the lock until the editor exits; invalid JSON is moved aside like a corrupt file")
+	pflag.Bool("oldest", false, "Paste, pop or delete the oldest item instead of the latest one")
+	pflag.Bool("numbered", false, "Prefix listed items with the index to pass to --paste or --delete")
+	pflag.Bool("pick", false, "Select the item to paste interactively, or delete items with d; prompts for an index when not on a terminal")
+	pflag.Bool("peek", false, "Paste the item without moving it to the front of the clipboard")
+	pflag.Int("promote", 0, "Make the nth item the latest, like pasting it but without any output")
+	pflag.Int("pin", 0, "Pin or unpin the nth item; if n is not provided, toggle the latest item. Pinned items are kept when deleting all items or evicting old ones")
+	pflag.Bool("pop", false, "Paste the latest item and delete it from the clipboard")
+	pflag.IntSlice("range", nil, "Delete the items from the first to the last index, inclusive, with --delete; out of range indices are clamped")
+	pflag.Bool("raw", false, "Keep leading and trailing whitespace significant, so items differing only in whitespace are stored separately; the same as CLIP_TRIM=false")
+	pflag.Bool("regex", false, "Interpret the find or match text as a regular expression")
+	pflag.Int("width", 0, "Truncate listed items to the given number of columns; defaults to the terminal width when listing to a terminal, 0 never truncates")
+	pflag.Bool("stats", false, "Print a summary of the history: the number of items, their total and largest size, and the oldest and newest timestamps")
+	pflag.String("strip", "", "Trim the given characters from both ends of the pasted item, e.g. '%$ '; the stored item is left untouched")
+	pflag.String("strip-regex", "", "Trim what the regular expression matches at either end of the pasted item, e.g. '^\\S+@\\S+ \\$ '; the stored item is left untouched")
+	pflag.Bool("sensitive", false, "Mark the added item as sensitive, leaving it out of the list; items that look like credentials are marked automatically with CLIP_DETECT_SECRETS")
+	pflag.String("source", "", "Record where the added item came from, e.g. the command it was piped from; shown in the JSON list and with --verbose")
+	pflag.Bool("show-sensitive", false, "Also list the items marked as sensitive")
+	pflag.Bool("size", false, "Prefix listed items with their size in bytes, after the index if numbered")
+	pflag.StringSlice("tag", nil, "Tag the added item, or list only the items with all of the tags")
+	pflag.Bool("to-clipboard", false, "Also copy the pasted item to the system clipboard, using pbcopy, wl-copy, xclip, xsel or clip.exe")
+	pflag.Bool("to-tmux", false, "Also load the pasted item into a tmux buffer")
+	pflag.String("template", "", "Wrap the pasted item in the template, replacing its only %s with it, e.g. 'export TOKEN=%s'")
+	pflag.String("out", "", "Write the pasted item to the given file instead of stdout, replacing it; - means stdout")
+	pflag.Bool("with-hash", false, "Output the hash of the pasted item on a line of its own before the item")
+	pflag.Bool("watch", false, "Keep running and add every new value of the system clipboard until interrupted")
+	pflag.Duration("watch-interval", 500*time.Millisecond, "How often --watch polls the system clipboard")
+	pflag.String("serve", "", "Serve the history read-only over HTTP on the given address, e.g. :8099, until interrupted; requests must pass CLIP_SERVE_TOKEN as a bearer token")
+	pflag.Int("trim", 0, "Keep only the n latest items, and the pinned ones, removing the older items")
+	pflag.Bool("undo", false, "Restore the history to before the last change; undoing again redoes it")
+	pflag.BoolP("quiet", "q", false, "Only log errors, not warnings about problems clip recovers from")
+	pflag.Bool("verbose", false, "Also log what clip is doing, like which data file it uses and how duplicates are handled")
+	pflag.BoolP("version", "v", false, "Print version information, including the commit, build date and Go version; as JSON with --json")
 
 	// NoOptDefVal for flags
 	pFlag := pflag.Lookup("paste")
-	pFlag.NoOptDefVal = "0" // Default to pasting the last item if no argument is provided
+	pFlag.NoOptDefVal = latestIndex // Default to pasting the last item if no argument is provided
 	lFlag := pflag.Lookup("list")
 	lFlag.NoOptDefVal = "0,0" // Default to listing all items if no arguments are provided
 	dFlag := pflag.Lookup("delete")
-	dFlag.NoOptDefVal = "0" // Default to deleting the latest item if no argument is provided
+	dFlag.NoOptDefVal = latestIndex // Default to deleting the latest item if no argument is provided
+	eFlag := pflag.Lookup("edit")
+	eFlag.NoOptDefVal = "0" // Default to editing the latest item if no argument is provided
+
+	// Completion is set up once, keep it out of the usage
+	_ = pflag.CommandLine.MarkHidden("completion")
+	pinFlag := pflag.Lookup("pin")
+	pinFlag.NoOptDefVal = "0" // Default to pinning the latest item if no argument is provided
 	sFlag := pflag.Lookup("silent")
 	sFlag.Hidden = true // Hide the silent flag from the help output
 
-	pflag.Parse()
+	// Parse errors are reported like any other usage error, -h has already
+	// printed the usage
+	pflag.CommandLine.Init(os.Args[0], pflag.ContinueOnError)
+	if err := pflag.CommandLine.Parse(normalizeArgs(pflag.CommandLine, os.Args[1:])); errors.Is(err, pflag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		// The flag may not have been reached before the error
+		jsonErrors = slices.Contains(os.Args[1:], "--json-errors")
+		fail(withCode(codeUsage, err))
+	}
+	jsonErrors, _ = pflag.CommandLine.GetBool("json-errors")
+
+	// Set before anything is logged
+	if quiet, _ := pflag.CommandLine.GetBool("quiet"); quiet {
+		verbosity = VerbosityQuiet
+	} else if verbose, _ := pflag.CommandLine.GetBool("verbose"); verbose {
+		verbosity = VerbosityVerbose
+	}
+
+	// Set before parsing, which is where piped input is read
+	config := LoadConfig()
+	if config.LogFile != "" {
+		openHistoryLog(config.LogFile)
+	}
+	stdinTimeout = config.StdinTimeout
+	if config.MaxItemBytes > 0 {
+		maxPipeBytes = config.MaxItemBytes
+	}
 
-	app := NewApplication(Config{})
-	f, err := app.parse(pflag.CommandLine)
+	f, err := parse(pflag.CommandLine)
 	if err != nil {
-		pflag.Usage()
-		os.Exit(1)
+		fail(err)
 	}
 
-	close := func() {
-		if err := app.Close(); err != nil {
-			log.Printf("Error closing application: %v", err)
+	if f.File != "" {
+		config.DataFile = f.File
+	}
+	config.Namespace = f.Namespace
+	if f.Raw {
+		config.Trim = false
+	}
+
+	// Watching opens the history for every change on its own
+	if f.Operation == OpWatch {
+		if err := watch(config, f); err != nil {
+			fail(err)
 		}
+		return
 	}
-	defer close()
 
-	if err := app.handle(f); err != nil {
-		if !errors.Is(err, pflag.ErrHelp) {
-			log.Println(err.Error())
+	// Like watching, serving opens the history for every request on its own
+	if f.Operation == OpServe {
+		if err := serve(config, f); err != nil {
+			fail(err)
 		}
-		pflag.Usage()
-		close()
-		os.Exit(1)
+		return
+	}
+
+	// Completion scripts only depend on the flags
+	if f.Operation == OpCompletion {
+		script, err := completion(f.Shell, pflag.CommandLine)
+		if err != nil {
+			fail(withCode(codeUsage, err))
+		}
+		Out(script)
+		return
+	}
+
+	// Listing namespaces doesn't need to load any of them
+	if f.Operation == OpNamespaces {
+		names, err := namespaces(config)
+		if err != nil {
+			fail(err)
+		}
+		for _, name := range names {
+			Outln(name)
+		}
+		return
+	}
+
+	// Resolving the path must not create the data file or its directory
+	if f.Operation == OpWhere {
+		filePath, err := dataFile(config)
+		if err != nil {
+			fail(withCode(codeUsage, err))
+		}
+		Outln(filePath)
+		return
+	}
+
+	app := NewApplication(config)
+
+	// Whatever changed before a failure is still saved
+	before, revision := app.Len(), app.Revision()
+	err = app.handle(f)
+	// Operations like pasting only change the history sometimes, so whether
+	// it changed is up to the store
+	changed := app.Revision() != revision
+	if changed && app.ReadOnly() && err == nil {
+		err = withCode(codeStorage, store.ErrReadOnly)
+	}
+	if changed || err != nil && f.Operation.mutates() {
+		app.logChange(f.Operation, before, err)
+	}
+	if closeErr := app.Close(); closeErr != nil && err != nil {
+		Errorf("%v", closeErr)
+	} else if closeErr != nil {
+		err = withCode(codeStorage, closeErr)
+	}
+	if err != nil {
+		fail(err)
 	}
 }
 
 func (app *application) handle(flags Flags) error {
+	if app.ReadOnly() && flags.Operation.mutates() {
+		return withCode(codeStorage, store.ErrReadOnly)
+	}
+
 	switch flags.Operation {
 	case OpHelp:
 		pflag.Usage()
 	case OpVersion:
-		Outln(version)
+		info := build()
+		if flags.JSON {
+			data, err := json.Marshal(info)
+			if err != nil {
+				return fmt.Errorf("error encoding version: %w", err)
+			}
+			Outln(string(data))
+			return nil
+		}
+		Outln(info.Version)
+		Outf("commit: %s\n", info.Commit)
+		Outf("ref: %s\n", info.Ref)
+		Outf("date: %s\n", info.Date)
+		Outf("go: %s\n", info.Go)
 	case OpAdd:
+		// Marks and tags what Add or AddBinary returned
+		added := make(map[string]bool)
+		add := func(item *store.Item, err error) error {
+			if err != nil {
+				return err
+			}
+			added[item.Hash] = true
+			sensitive := flags.Sensitive && !item.Sensitive
+			item.Sensitive = item.Sensitive || flags.Sensitive
+			source := flags.Source != "" && item.Source != flags.Source
+			if source {
+				item.Source = flags.Source
+			}
+			if item.Tag(flags.Tags...) || sensitive || source {
+				app.Touch()
+			}
+			return nil
+		}
+		if flags.FromClipboard {
+			text, err := fromClipboard()
+			if err != nil {
+				return fmt.Errorf("error reading the system clipboard: %w", err)
+			}
+			if strings.TrimSpace(text) == "" {
+				return nil // Nothing to add, like empty piped input
+			}
+			flags.Text = text
+		} else if flags.FromTmux {
+			text, err := fromTmux()
+			if err != nil {
+				return fmt.Errorf("error reading the tmux buffer: %w", err)
+			}
+			if strings.TrimSpace(text) == "" {
+				return nil
+			}
+			flags.Text = text
+		}
 		if flags.Text == "" {
 			return fmt.Errorf("no text provided to add to the clipboard")
 		}
-		app.Add(flags.Text)
-		if !flags.Silent {
+		if flags.Base64 {
+			// NOTE: Encoders commonly wrap lines, so ignore all whitespace
+			data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(flags.Text), ""))
+			if err != nil {
+				return fmt.Errorf("invalid base64: %w", err)
+			}
+			if err := add(app.AddBinary(data)); err != nil {
+				return err
+			}
+		} else if flags.Texts != nil {
+			// Add the oldest first so the first string ends up the newest, the
+			// same order the list prints them in
+			for _, text := range slices.Backward(flags.Texts) {
+				if strings.TrimSpace(text) == "" {
+					continue
+				}
+				if err := add(app.Add(text)); err != nil {
+					Warnf("Skipping item: %v", err)
+				}
+			}
+		} else if flags.Lines {
+			// Each line is its own item, the last line ends up the newest
+			for _, line := range strings.Split(flags.Text, "\n") {
+				line = strings.TrimSuffix(line, "\r")
+				if strings.TrimSpace(line) == "" {
+					continue
+				}
+				if err := add(app.Add(line)); err != nil {
+					Warnf("Skipping line: %v", err)
+				}
+			}
+		} else if err := add(app.Add(flags.Text)); err != nil {
+			return err
+		}
+		// NOTE: Every added item ends up the latest, so the distinct ones are
+		// the newest len(added) items
+		switch {
+		case flags.Silent:
+		case flags.Confirm && len(added) == 1:
+			Outln("added (#0)")
+		case flags.Confirm && len(added) > 1:
+			Outf("added %s (#0 to #%d)\n", store.Plural(len(added), "item"), len(added)-1)
+		case flags.Confirm:
+			Outln("added nothing")
+		default:
 			Out(flags.Text)
 		}
 	case OpPaste:
-		if len(app.Items) == 0 {
+		if app.Len() == 0 {
+			if flags.Oldest {
+				return errEmpty
+			}
 			return nil
 		}
-		idx, err := resolveIdx(flags.PasteIndex, len(app.Items))
+		if flags.Match != "" {
+			if _, exists := app.lookup(flags.PipeInput); exists {
+				return withCode(codePipeInput, fmt.Errorf("piped input cannot be used when pasting a match"))
+			}
+
+			// Paste the latest item containing the text, or matching the
+			// expression, the same way deleting by match does
+			match, err := matcher(Flags{Query: flags.Match, Regex: flags.Regex, IgnoreCase: flags.IgnoreCase})
+			if err != nil {
+				return err
+			}
+			found := false
+			for i, item := range app.Range {
+				if !item.Binary && match(item.Data) {
+					flags.PasteIndex = app.Len() - i - 1
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("no item matches %q", flags.Match)
+			}
+			flags.PipeInput = ""
+			// Looking an item up by match never reorders the history, as if
+			// --peek was passed
+			flags.Peek = true
+		}
+		if flags.PasteRange {
+			if _, exists := app.lookup(flags.PipeInput); exists {
+				return withCode(codePipeInput, fmt.Errorf("piped input cannot be used when pasting a range"))
+			}
+			return app.pasteRange(flags)
+		}
+		if idx, exists := app.lookup(flags.PipeInput); exists {
+			// The piped item wins over the default index, but not over one that
+			// was passed explicitly, even if it is 0
+			if flags.PasteIndexSet {
+				return withCode(codePipeInput, fmt.Errorf("piped input cannot be used when pasting an item by index"))
+			}
+
+			// we need to invert the index (len - idx - 1)
+			flags.PasteIndex = app.Len() - idx - 1
+		}
+
+		idx, err := resolveIdx(flags.PasteIndex, app.Len())
 		if err != nil {
 			return err
 		}
 
 		item := app.Get(idx)
 		if item == nil {
-			return fmt.Errorf("item not found at index %d", idx)
+			return withCode(codeOutOfRange, fmt.Errorf("item not found at index %d", idx))
 		}
 
 		// Bring this item to the front of the list
-		// Unless it's already the latest item
-		if idx != len(app.Items)-1 {
-			app.Remove(idx)
-			app.Add(item.Data) // Re-add it to the end of the list
-		}
-
-		// TODO: Allow adding a new line if they want it
-		Out(item.Data)
-	case OpDeleteAll:
-		app.Clear()
-	case OpDelete:
-		var indices []int
-		if len(flags.DeleteIndices) == 0 {
-			indices = []int{0} // Default to deleting the latest item
-		} else {
-			indices = flags.DeleteIndices
+		// Unless it's already the latest item, or we're only peeking. Pastes
+		// that don't reorder are pure reads and leave the data file untouched.
+		if app.config.ReorderOnPaste && !flags.Peek && !app.ReadOnly() && idx < app.Len()-1 {
+			item.Accessed = time.Now().Unix()
+			app.Promote(idx)
 		}
 
-		// Sanitize indices to ensure they are within bounds
-		for i, idx := range indices {
-			idx, err := resolveIdx(idx, len(app.Items))
-			if err != nil {
-				return err
+		return output(item, flags)
+	case OpPop:
+		if app.Len() == 0 {
+			if flags.Oldest {
+				return errEmpty
 			}
-			indices[i] = idx
+			return nil
 		}
 
-		// sort descending order to avoid index shifting issues
-		slices.Sort(indices)
-		slices.Reverse(indices)
-
-		for _, i := range indices {
-			app.Remove(i)
+		idx := app.Len() - 1
+		if flags.Oldest {
+			idx = 0
 		}
-	case OpList:
-		if len(app.Items) == 0 {
-			return nil // No items to list
+		// Only removed once it was output, a failed write would lose it
+		if err := output(app.Get(idx), flags); err != nil {
+			return err
 		}
-
-		start, end := flags.ListArgs[0], flags.ListArgs[1]
-		if start == 0 && end == 0 {
-			// List all items (in reverse order)
-			for i := len(app.Items) - 1; i >= 0; i-- {
-				item := app.Items[i]
-				Outln(strings.ReplaceAll(item.Data, "\n", "\\n"))
+		app.Remove(idx)
+	case OpPin:
+		idx, err := resolveIdx(flags.PinIndex, app.Len())
+		if err != nil {
+			return err
+		}
+		item := app.Get(idx)
+		item.Pinned = !item.Pinned
+		app.Touch()
+	case OpPromote:
+		idx, err := resolveIdx(flags.PromoteIndex, app.Len())
+		if err != nil {
+			return err
+		}
+		if idx == app.Len()-1 {
+			if !flags.Silent {
+				Warnf("Item %d is already the latest", flags.PromoteIndex)
 			}
-		} else {
-			// IMPLEMENT: Limit and range listing
-			panic("Not implemented yet")
+			return nil
 		}
-	default:
-		return fmt.Errorf("unknown operation: %v", flags.Operation)
-	}
-	return nil
-}
-
-func resolveIdx(idx int, len int) (int, error) {
-	if idx < 0 {
-		idx = idx*-1 - 1
-	} else {
-		idx = len - idx - 1
-	}
-
-	if idx < 0 || idx >= len {
-		return 0, fmt.Errorf("index %d out of bounds for length %d", idx, len)
-	}
-
-	return idx, nil
-}
-
-func (app *application) parse(flagset *pflag.FlagSet) (Flags, error) {
-	var flags Flags
-	flags.Operation = OpHelp // Default operation
-
-	emptyArg0 := true
-	if flagset.NArg() > 0 {
-		// NOTE: No need to allow empty space to be copied
-		// Instead we could utilize this to paste into an empty space in nvim.
-		emptyArg0 = strings.TrimSpace(flagset.Arg(0)) == ""
-		if !emptyArg0 {
-			// Try again but unescaped
-			emptyArg0 = strings.TrimSpace(strings.ReplaceAll(flagset.Arg(0), "\\n", "\n")) == ""
+		app.Get(idx).Accessed = time.Now().Unix()
+		app.Promote(idx)
+	case OpEdit:
+		idx, err := resolveIdx(flags.EditIndex, app.Len())
+		if err != nil {
+			return err
 		}
-	}
 
-	if flagset.Changed("version") {
-		v, err := flagset.GetBool("version")
+		item := app.Get(idx)
+		data, err := edit(item.Data)
 		if err != nil {
-			return flags, err
+			return err
 		}
-		if v {
-			flags.Operation = OpVersion
+		if data == item.Data {
+			return nil // Nothing changed
 		}
-	} else if flagset.Changed("delete-all") {
-		d, err := flagset.GetBool("delete-all")
-		if err != nil {
-			return flags, err
+		if strings.TrimSpace(data) == "" {
+			return fmt.Errorf("edited item is empty, leaving it untouched")
 		}
-		if d {
-			flags.Operation = OpDeleteAll
+		if err := app.Update(idx, data); err != nil {
+			return err
 		}
-	} else if flagset.Changed("delete") {
-		indices, err := flagset.GetIntSlice("delete")
+	case OpGetHash:
+		idx, err := app.FindHash(flags.GetHash)
 		if err != nil {
-			return flags, err
+			return err
 		}
-		if len(indices) == 0 || (len(indices) == 1 && indices[0] == 0) {
-			flags.Operation = OpDelete
-		} else {
-			flags.Operation = OpDelete
-			flags.DeleteIndices = indices
+		return output(app.Get(idx), flags)
+	case OpFirst, OpLast:
+		// Unlike --oldest, an empty history is nothing to output, not an error
+		if app.Len() == 0 {
+			return nil
 		}
-	} else if flagset.Changed("list") {
-		listArgs, err := flagset.GetIntSlice("list")
-		if err != nil {
-			return flags, err
+		idx := app.Len() - 1
+		if flags.Operation == OpFirst {
+			idx = 0
 		}
-		if len(listArgs) == 0 {
-			flags.Operation = OpList
-		} else if len(listArgs) == 1 {
-			flags.Operation = OpList
-			flags.ListArgs[0] = listArgs[0]
-		} else if len(listArgs) == 2 {
-			flags.Operation = OpList
-			flags.ListArgs[0] = listArgs[0]
-			flags.ListArgs[1] = listArgs[1]
-		} else {
-			log.Println("Invalid number of arguments for list operation")
-			return flags, pflag.ErrHelp
+		return output(app.Get(idx), flags)
+	case OpPick:
+		if app.Len() == 0 {
+			return nil
 		}
-	} else if flagset.Changed("paste") {
+		idx, ok, err := app.pick(flags)
+		if err != nil || !ok {
+			return err
+		}
+
+		// Paste the selection like any other item
 		flags.Operation = OpPaste
-		paste, _ := flagset.GetInt("paste")
-		flags.PasteIndex = paste
-		// NOTE: Support piping back fzf of list output
-		// Ex: `clip -l | fzf | clip -p`
-		pipeInput, err := getPipeInput()
+		flags.PasteIndex = app.Len() - idx - 1
+		return app.handle(flags)
+	case OpUndo:
+		if err := app.Undo(); err != nil && !errors.Is(err, store.ErrNoUndo) {
+			return withCode(codeStorage, err)
+		} else if err != nil {
+			return err
+		}
+	case OpMove:
+		from, err := resolveIdx(flags.MoveArgs[0], app.Len())
+		if err != nil {
+			return err
+		}
+		to, err := resolveIdx(flags.MoveArgs[1], app.Len())
+		if err != nil {
+			return err
+		}
+		app.Move(from, to)
+	case OpImport:
+		added, err := app.Import(flags.ImportData)
+		if err != nil {
+			return err
+		}
+		if !flags.Silent {
+			Outf("Imported %s\n", store.Plural(added, "new item"))
+		}
+	case OpExport:
+		// The export uses the same format as the data file, in storage order
+		data, err := app.Export()
+		if err != nil {
+			return fmt.Errorf("error encoding export: %w", err)
+		}
+		Outln(string(data))
+	case OpStats:
+		app.stats()
+	case OpOpen:
+		// NOTE: Compressed and encrypted files are binary, plain ones are a JSON
+		// object
+		data, err := os.ReadFile(app.Path())
+		if err != nil {
+			return withCode(codeStorage, fmt.Errorf("error reading data file: %w", err))
+		}
+		if len(data) > 0 && data[0] != '{' {
+			return fmt.Errorf("the data file is compressed or encrypted, it can't be edited as JSON")
+		}
+
+		// The lock is still held, so nothing else writes the file meanwhile
+		if err := runEditor(app.Path()); err != nil {
+			return err
+		}
+		if err := app.Reload(); err != nil {
+			return withCode(codeStorage, err)
+		}
+	case OpDuplicates:
+		return app.duplicates(flags)
+	case OpCount:
+		Outln(strconv.Itoa(app.Len()))
+	case OpTrim:
+		if flags.DryRun {
+			// The same items Truncate removes, the unpinned ones after the
+			// first TrimCount
+			var indices []int
+			kept := 0
+			for i, item := range app.Range {
+				if item.Pinned {
+					continue
+				}
+				if kept < flags.TrimCount {
+					kept++
+					continue
+				}
+				indices = append(indices, i)
+			}
+			app.preview(indices)
+			return nil
+		}
+		removed := app.Truncate(flags.TrimCount)
+		if !flags.Silent {
+			Outf("Removed %s\n", store.Plural(removed, "item"))
+		}
+	case OpDedup:
+		removed := app.Dedup()
+		if !flags.Silent {
+			Outf("Removed %s\n", store.Plural(removed, "duplicate"))
+		}
+	case OpDeleteAll:
+		if flags.DryRun {
+			var indices []int
+			for i, item := range app.Range {
+				if !item.Pinned {
+					indices = append(indices, i)
+				}
+			}
+			app.preview(indices)
+			return nil
+		}
+		app.Clear()
+	case OpDelete:
+		if flags.Oldest && app.Len() == 0 {
+			return errEmpty
+		}
+
+		var indices []int
+		if flags.Match != "" {
+			// Delete every item containing the text, or matching the expression
+			match, err := matcher(Flags{Query: flags.Match, Regex: flags.Regex, IgnoreCase: flags.IgnoreCase})
+			if err != nil {
+				return err
+			}
+			for i, item := range app.List() {
+				// Binary items would match their base64 encoding
+				if !item.Binary && match(item.Data) {
+					indices = append(indices, i)
+				}
+			}
+		} else if flags.DeleteRange {
+			// Clamp the bounds to the history, counting from either end
+			n := app.Len()
+			if n == 0 {
+				return nil
+			}
+			clamp := func(idx int) int {
+				if idx < 0 {
+					return max(idx, -n)
+				}
+				return min(idx, n-1)
+			}
+			from, err := resolveIdx(clamp(flags.DeleteIndices[0]), n)
+			if err != nil {
+				return err
+			}
+			to, err := resolveIdx(clamp(flags.DeleteIndices[1]), n)
+			if err != nil {
+				return err
+			}
+			for i := min(from, to); i <= max(from, to); i++ {
+				indices = append(indices, i)
+			}
+		} else if len(flags.Hashes) > 0 {
+			// Delete exactly the items with the hashes
+			for _, hash := range flags.Hashes {
+				idx, exists := app.Index(hash)
+				if !exists {
+					return fmt.Errorf("no such hash: %s", hash)
+				}
+				if !slices.Contains(indices, idx) {
+					indices = append(indices, idx)
+				}
+			}
+		} else {
+			if len(flags.DeleteIndices) == 0 && app.Len() == 0 {
+				return nil // Nothing to delete, like pasting from an empty history
+			} else if len(flags.DeleteIndices) == 0 {
+				indices = []int{0} // Default to deleting the latest item
+			} else {
+				indices = flags.DeleteIndices
+			}
+
+			// Sanitize indices to ensure they are within bounds
+			for i, idx := range indices {
+				idx, err := resolveIdx(idx, app.Len())
+				if err != nil {
+					return err
+				}
+				indices[i] = idx
+			}
+		}
+
+		// sort descending order to avoid index shifting issues, indices with
+		// mixed signs may refer to the same item so only remove it once
+		slices.Sort(indices)
+		indices = slices.Compact(indices)
+		slices.Reverse(indices)
+
+		if flags.DryRun {
+			app.preview(indices)
+			return nil
+		}
+
+		for _, i := range indices {
+			app.Remove(i)
+		}
+
+		if (flags.Match != "" || flags.DeleteRange) && !flags.Silent {
+			Outf("Deleted %s\n", store.Plural(len(indices), "item"))
+		}
+	case OpList, OpFind:
+		return app.list(flags)
+	default:
+		return fmt.Errorf("unknown operation: %v", flags.Operation)
+	}
+	return nil
+}
+
+// lookup finds the item matching a line of list output piped back into clip,
+// returning its position in Items. An exact match is preferred, otherwise the
+// newest item starting with the input is used, so truncated lines still
+// resolve; if several items share the prefix the newest one wins.
+func (app *application) lookup(input string) (int, bool) {
+	if input == "" {
+		return 0, false
+	}
+
+	// NOTE: NUL delimited list output is selected with its delimiter, e.g.
+	// `clip -l -0 | fzf --read0 --print0 | clip -p`
+	input = strings.TrimSuffix(input, "\x00")
+	input = ansiCode.ReplaceAllString(input, "")
+
+	// NOTE: Since we escape the list output, let's unescape it,
+	// pinned items are also marked and numbered or tagged items have extra
+	// columns so try without those as well
+	candidates := []string{unescape(input), input}
+	stripped := indexColumn.ReplaceAllString(strings.TrimRight(input, "\r\n"), "")
+	stripped = sizeColumn.ReplaceAllString(stripped, "")
+	stripped = hashColumn.ReplaceAllString(stripped, "")
+	if i := strings.LastIndex(stripped, tagSeparator); i >= 0 {
+		stripped = stripped[:i]
+	}
+	stripped = strings.TrimPrefix(stripped, pinMarker)
+	if stripped != input {
+		candidates = append(candidates, unescape(stripped), stripped)
+	}
+
+	for _, candidate := range candidates {
+		if idx, exists := app.Index(app.Hash(candidate)); exists {
+			return idx, true
+		}
+	}
+
+	// Truncated items only match by prefix, the ellipsis is not part of them
+	if truncated := strings.TrimSuffix(stripped, ellipsis); truncated != stripped {
+		candidates = append(candidates, unescape(truncated), truncated)
+	}
+	hasPrefix := func(i int) bool {
+		if app.Get(i).Binary {
+			return stripped == binaryLabel
+		}
+		data := strings.TrimSpace(app.Get(i).Data)
+		for _, candidate := range candidates {
+			prefix := strings.TrimSpace(candidate)
+			if prefix != "" && strings.HasPrefix(data, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Numbered lines name their item, as long as it still matches; this tells
+	// apart truncated items sharing a prefix
+	if column := indexColumn.FindString(input); column != "" {
+		n, _ := strconv.Atoi(strings.TrimSpace(column))
+		if i := app.Len() - n - 1; i >= 0 && i < app.Len() && hasPrefix(i) {
+			return i, true
+		}
+	}
+
+	// Fall back to a prefix match, newest first
+	for i := range app.Range {
+		if hasPrefix(i) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// runEditor opens the file in $EDITOR, or vi if unset, and waits for it to
+// exit.
+func runEditor(path string) error {
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+
+	cmd := exec.Command(editor[0], append(editor[1:], path)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error running editor: %w", err)
+	}
+	return nil
+}
+
+// edit opens the data in $EDITOR and returns the saved content.
+func edit(data string) (string, error) {
+	file, err := os.CreateTemp("", "clip-*.txt")
+	if err != nil {
+		return "", fmt.Errorf("error creating temporary file: %w", err)
+	}
+	defer func() {
+		if err := os.Remove(file.Name()); err != nil {
+			Warnf("Failed to remove temporary file: %v", err)
+		}
+	}()
+
+	if _, err := file.WriteString(data); err != nil {
+		file.Close()
+		return "", fmt.Errorf("error writing temporary file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("error closing temporary file: %w", err)
+	}
+
+	if err := runEditor(file.Name()); err != nil {
+		return "", err
+	}
+
+	edited, err := os.ReadFile(file.Name())
+	if err != nil {
+		return "", fmt.Errorf("error reading temporary file: %w", err)
+	}
+
+	// Editors usually add a trailing newline on save, drop it unless the data
+	// already had one
+	if strings.HasSuffix(data, "\n") {
+		return string(edited), nil
+	}
+	return strings.TrimSuffix(string(edited), "\n"), nil
+}
+
+// output writes the data of a pasted item.
+func output(item *store.Item, flags Flags) error {
+	return outputAll([]*store.Item{item}, flags)
+}
+
+// outputAll pastes the items joined by the separator.
+func outputAll(items []*store.Item, flags Flags) error {
+	strip, err := stripper(flags.Strip, flags.StripRegex)
+	if err != nil {
+		return err
+	}
+
+	parts := make([]string, len(items))
+	for i, item := range items {
+		data := item.Data
+		if item.Binary && !flags.Base64 {
+			decoded, err := base64.StdEncoding.DecodeString(data)
+			if err != nil {
+				return fmt.Errorf("error decoding binary item: %w", err)
+			}
+			data = string(decoded)
+		} else if !item.Binary && flags.Base64 {
+			data = base64.StdEncoding.EncodeToString([]byte(data))
+		} else if !item.Binary {
+			data = strip(data)
+		}
+		parts[i] = data
+	}
+	data := strings.Join(parts, flags.Separator)
+	if flags.Template != "" {
+		// NOTE: Not fmt.Sprintf, any other % is kept as is
+		data = strings.Replace(flags.Template, "%s", data, 1)
+	}
+
+	if flags.ToClipboard {
+		// The item is still written to stdout, so this is only a warning
+		if err := toClipboard(data); err != nil {
+			Warnf("Failed to copy to the system clipboard: %v", err)
+		}
+	}
+	if flags.ToTmux {
+		if err := toTmux(data); err != nil {
+			Warnf("Failed to load the tmux buffer: %v", err)
+		}
+	}
+
+	if flags.Newline {
+		data += "\n"
+	}
+	if flags.WithHash {
+		// Ranges are rejected when parsing, so there is a single item
+		data = items[0].Hash + "\n" + data
+	}
+	if flags.Out != "" {
+		return writeOut(flags.Out, data)
+	}
+	Out(data)
+	return nil
+}
+
+// stripper returns a function trimming the characters from both ends of the
+// data, or with regex what the expression matches at either end. Nothing is
+// trimmed if there are no characters.
+func stripper(chars string, regex bool) (func(string) string, error) {
+	if chars == "" {
+		return func(data string) string { return data }, nil
+	} else if !regex {
+		return func(data string) string { return strings.Trim(data, chars) }, nil
+	}
+
+	if _, err := regexp.Compile(chars); err != nil {
+		return nil, err
+	}
+	ends := regexp.MustCompile(`^(?:` + chars + `)|(?:` + chars + `)$`)
+	return func(data string) string { return ends.ReplaceAllString(data, "") }, nil
+}
+
+// writeOut replaces the file with the data. It is written to a temporary file
+// in the same directory first, so an interrupted paste never leaves the file
+// truncated. An existing file keeps its permissions.
+func writeOut(path, data string) error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("error opening output file: %w", err)
+	}
+	tmpPath := file.Name()
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		if err := file.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+			Warnf("Failed to close file: %v", err)
+		}
+		if err := os.Remove(tmpPath); err != nil {
+			Warnf("Failed to remove temporary file: %v", err)
+		}
+	}()
+
+	if err := file.Chmod(mode); err != nil {
+		return fmt.Errorf("error setting output file permissions: %w", err)
+	}
+	if _, err := file.WriteString(data); err != nil {
+		return fmt.Errorf("error writing output file: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("error syncing output file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error closing output file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("error replacing output file: %w", err)
+	}
+	committed = true
+	return nil
+}
+
+// stats prints a summary of the history, one "key: value" per line.
+func (app *application) stats() {
+	total, largest, pinned := 0, 0, 0
+	var oldest, newest int64
+	for _, item := range app.List() {
+		total += item.Size()
+		largest = max(largest, item.Size())
+		if item.Pinned {
+			pinned++
+		}
+		if item.Created != 0 && (oldest == 0 || item.Created < oldest) {
+			oldest = item.Created
+		}
+		newest = max(newest, item.Created)
+	}
+
+	Outf("items: %d\n", app.Len())
+	Outf("pinned: %d\n", pinned)
+	Outf("bytes: %d\n", total)
+	Outf("largest: %d\n", largest)
+	// Items from before timestamps were tracked have none
+	if oldest != 0 {
+		Outf("oldest: %s\n", time.Unix(oldest, 0).Format(time.RFC3339))
+		Outf("newest: %s\n", time.Unix(newest, 0).Format(time.RFC3339))
+	}
+}
+
+// duplicateGroup is a hash shared by several items, with their indices newest
+// first.
+type duplicateGroup struct {
+	Hash    string `json:"hash"`
+	Indices []int  `json:"indices"`
+}
+
+// duplicates lists the groups of items sharing a hash, newest group first,
+// which --dedup would collapse. The index should map every hash to the newest
+// item of its group, which is checked along the way.
+func (app *application) duplicates(flags Flags) error {
+	var hashes []string
+	indices := make(map[string][]int)
+	for i, item := range app.Range {
+		if _, seen := indices[item.Hash]; !seen {
+			hashes = append(hashes, item.Hash)
+			if idx, exists := app.Index(item.Hash); !exists || idx != i {
+				Warnf("The index maps %s to %d instead of %d", item.Hash, idx, i)
+			}
+		}
+		indices[item.Hash] = append(indices[item.Hash], app.Len()-i-1)
+	}
+
+	groups := []duplicateGroup{}
+	for _, hash := range hashes {
+		if len(indices[hash]) > 1 {
+			groups = append(groups, duplicateGroup{Hash: hash, Indices: indices[hash]})
+		}
+	}
+
+	if flags.JSON {
+		data, err := json.Marshal(groups)
+		if err != nil {
+			return fmt.Errorf("error encoding duplicates: %w", err)
+		}
+		Outln(string(data))
+		return nil
+	}
+	for _, group := range groups {
+		strs := make([]string, len(group.Indices))
+		for i, idx := range group.Indices {
+			strs[i] = strconv.Itoa(idx)
+		}
+		Outf("%s\t%s\n", group.Hash, strings.Join(strs, ","))
+	}
+	return nil
+}
+
+// errEmpty is returned when an operation needs an item but there are none.
+var errEmpty = errors.New("the clipboard is empty")
+
+func resolveIdx(idx int, len int) (int, error) {
+	resolved := len - idx - 1
+	if idx < 0 {
+		resolved = idx*-1 - 1
+	}
+
+	if resolved < 0 || resolved >= len {
+		return 0, withCode(codeOutOfRange, fmt.Errorf("index %d out of bounds for length %d", idx, len))
+	}
+
+	return resolved, nil
+}
+
+// listEntry is an item in the list output along with the index used to
+// reference it with -p and -d.
+type listEntry struct {
+	Index     int      `json:"index"`
+	Data      string   `json:"data"`
+	Hash      string   `json:"hash"`
+	Pinned    bool     `json:"pinned,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Size      int      `json:"size,omitempty"`
+	Binary    bool     `json:"binary,omitempty"`
+	Sensitive bool     `json:"sensitive,omitempty"`
+	Source    string   `json:"source,omitempty"`
+}
+
+// listEntries returns the items the list shows, in the order it shows them.
+func (app *application) listEntries(flags Flags) ([]listEntry, error) {
+	match, err := matcher(flags)
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect matching items (in reverse order), offsets are counted from the
+	// newest match
+	entries := []listEntry{}
+	seen := make(map[string]bool)
+	for i, item := range app.Range {
+		if item.Sensitive && !flags.ShowSensitive {
+			continue
+		}
+		// Binary items would match their base64 encoding, so only an empty
+		// query lists them
+		if item.Binary && flags.Query != "" {
+			continue
+		}
+		if match(item.Data) && item.HasTags(flags.Tags) && strings.HasPrefix(item.Hash, flags.HashPrefix) {
+			if flags.UniquePrefix > 0 {
+				// Only the newest match of those sharing a prefix is listed
+				prefix := prefixOf(item.Data, flags.UniquePrefix)
+				if seen[prefix] {
+					continue
+				}
+				seen[prefix] = true
+			}
+
+			entries = append(entries, listEntry{
+				Index:     app.Len() - i - 1,
+				Data:      item.Data,
+				Hash:      item.Hash,
+				Pinned:    item.Pinned,
+				Tags:      item.Tags,
+				Binary:    item.Binary,
+				Sensitive: item.Sensitive,
+				Source:    item.Source,
+			})
+			if flags.Size {
+				entries[len(entries)-1].Size = item.Size()
+			}
+		}
+	}
+
+	start, end := listBounds(flags.ListArgs, len(entries))
+	entries = entries[start:end]
+	if flags.Chronological {
+		// The bounds still select the newest items, only their order changes
+		slices.Reverse(entries)
+	}
+	return entries, nil
+}
+
+// prefixOf returns the first n characters of the data.
+func prefixOf(data string, n int) string {
+	for i := range data {
+		if n == 0 {
+			return data[:i]
+		}
+		n--
+	}
+	return data
+}
+
+func (app *application) list(flags Flags) error {
+	entries, err := app.listEntries(flags)
+	if err != nil {
+		return err
+	}
+
+	if flags.JSON {
+		data, err := json.Marshal(entries)
+		if err != nil {
+			return fmt.Errorf("error encoding list: %w", err)
+		}
+		Outln(string(data))
+		return nil
+	}
+
+	// Align the index column to the widest index
+	width := 0
+	if flags.Numbered && len(entries) > 0 {
+		width = len(strconv.Itoa(slices.MaxFunc(entries, func(a, b listEntry) int {
+			return a.Index - b.Index
+		}).Index))
+	}
+
+	// Same for the size column
+	sizeWidth := 0
+	if flags.Size && len(entries) > 0 {
+		sizeWidth = len(strconv.Itoa(slices.MaxFunc(entries, func(a, b listEntry) int {
+			return a.Size - b.Size
+		}).Size))
+	}
+
+	var highlight *regexp.Regexp
+	if flags.Color && flags.Query != "" {
+		highlight, err = highlighter(flags)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range entries {
+		if flags.Null {
+			// Items are output verbatim, so no escaping is needed
+			Out(entry.Data + "\x00")
+			continue
+		}
+
+		var index, size, hash, pin, suffix string
+		if flags.Numbered {
+			index = fmt.Sprintf("%*d\t", width, entry.Index)
+		}
+		if flags.Size {
+			size = fmt.Sprintf("%*dB\t", sizeWidth, entry.Size)
+		}
+		if flags.HashPrefix != "" {
+			hash = entry.Hash + "\t"
+		}
+		if entry.Pinned {
+			pin = pinMarker
+		}
+		if len(entry.Tags) > 0 {
+			suffix = tagSeparator + strings.Join(entry.Tags, ",")
+		}
+
+		// Not a column, which piping the list back into clip -p would have to
+		// strip, so only logged
+		if entry.Source != "" {
+			Debugf("Item %d came from %s", entry.Index, entry.Source)
+		}
+
+		line := escape(entry.Data)
+		if entry.Binary {
+			line = binaryLabel
+		} else if flags.Width > 0 {
+			line = truncate(entry.Data, flags.Width-columns(index+size+hash+pin)-columns(suffix))
+		}
+
+		// Only the terminal gets colors, never the pipe back into clip -p
+		if flags.Color {
+			index = paint(colorIndex, index)
+			size = paint(colorDim, size)
+			hash = paint(colorDim, hash)
+			pin = paint(colorPin, pin)
+			suffix = paint(colorDim, suffix)
+			if highlight != nil && !entry.Binary {
+				line = highlight.ReplaceAllStringFunc(line, func(match string) string {
+					return paint(colorMatch, match)
+				})
+			}
+		}
+		Outln(index + size + hash + pin + line + suffix)
+	}
+	return nil
+}
+
+// matcher returns a predicate for the query in the flags, which is either a
+// plain substring or a regular expression. An empty query matches everything.
+func matcher(flags Flags) (func(string) bool, error) {
+	if !flags.Regex && flags.IgnoreCase {
+		query := strings.ToLower(flags.Query)
+		return func(data string) bool {
+			return strings.Contains(strings.ToLower(data), query)
+		}, nil
+	} else if !flags.Regex {
+		return func(data string) bool {
+			return strings.Contains(data, flags.Query)
+		}, nil
+	}
+
+	query := flags.Query
+	if flags.IgnoreCase {
+		query = "(?i)" + query
+	}
+	re, err := regexp.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %w", err)
+	}
+	return re.MatchString, nil
+}
+
+const (
+	// pinMarker prefixes pinned items in the list output.
+	pinMarker = "* "
+	// tagSeparator separates the trailing tags column in the list output.
+	tagSeparator = "\t#"
+	// binaryLabel replaces the data of binary items in the list output.
+	binaryLabel = "[binary]"
+)
+
+// indexColumn matches the leading index column of numbered list output.
+var indexColumn = regexp.MustCompile(`^ *\d+\t`)
+
+// sizeColumn matches the size column of list output, following the index
+// column if numbered.
+var sizeColumn = regexp.MustCompile(`^ *\d+B\t`)
+
+// hashColumn matches the hash column of list output with --hash-prefix,
+// following the size if any.
+var hashColumn = regexp.MustCompile(`^[A-Za-z0-9_-]{43}\t`)
+
+// escaper and unescaper are exact inverses: backslashes are escaped as well,
+// so a literal \n in the data is listed as \\n and never mistaken for a
+// newline. Tabs are escaped since they delimit the list columns.
+var (
+	escaper   = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	unescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\r`, "\r", `\t`, "\t")
+)
+
+// escape makes the data fit on a single line of list output; the paste path
+// reverses it with unescape when matching piped input.
+func escape(data string) string {
+	return escaper.Replace(data)
+}
+
+// unescape reverses escape.
+func unescape(data string) string {
+	return unescaper.Replace(data)
+}
+
+// ANSI colors of the list output.
+const (
+	colorReset = "\x1b[0m"
+	colorIndex = "\x1b[33m"
+	colorDim   = "\x1b[2m"
+	colorPin   = "\x1b[35m"
+	colorMatch = "\x1b[1;31m"
+)
+
+// ansiCode matches the ANSI colors, which the paste path ignores.
+var ansiCode = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// paint colors s, leaving it empty if it is.
+func paint(color, s string) string {
+	if s == "" {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// highlighter matches the query in the escaped list output, the same way the
+// matcher does in the data.
+func highlighter(flags Flags) (*regexp.Regexp, error) {
+	query := regexp.QuoteMeta(escape(flags.Query))
+	if flags.Regex {
+		query = flags.Query
+	}
+	if flags.IgnoreCase {
+		query = "(?i)" + query
+	}
+	re, err := regexp.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %w", err)
+	}
+	return re, nil
+}
+
+// isTerminal reports whether the file is a terminal rather than a pipe or a
+// regular file.
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// ellipsis marks list items truncated to the output width.
+const ellipsis = "…"
+
+// tabWidth is the most columns a tab takes up in the list output.
+const tabWidth = 8
+
+// terminalWidth returns the width of the terminal stdout is attached to, or 0
+// if stdout is not a terminal.
+func terminalWidth() int {
+	if !isTerminal(os.Stdout) {
+		return 0
+	}
+	if cols := ttyColumns(os.Stdout); cols > 0 {
+		return cols
+	}
+	cols, _ := strconv.Atoi(os.Getenv("COLUMNS"))
+	return max(cols, 0)
+}
+
+// columns is the most columns s can take up, assuming every tab is as wide as
+// it can be.
+func columns(s string) int {
+	return utf8.RuneCountInString(s) + strings.Count(s, "\t")*(tabWidth-1)
+}
+
+// truncate escapes the data and shortens it to at most n columns, ending it
+// with an ellipsis when anything was cut. Escape sequences are never split so
+// the paste path can still prefix match the truncated line.
+func truncate(data string, n int) string {
+	escaped := escape(data)
+	if utf8.RuneCountInString(escaped) <= n {
+		return escaped
+	}
+
+	var b strings.Builder
+	used := 0
+	for _, r := range data {
+		s := escape(string(r))
+		w := utf8.RuneCountInString(s)
+		if used+w > n-1 {
+			break
+		}
+		b.WriteString(s)
+		used += w
+	}
+	return b.String() + ellipsis
+}
+
+// listBounds resolves the list arguments into a [start, end) range of offsets
+// from the newest item, clamped to n items. An end of 0 means no upper bound,
+// so [0, 0] lists everything.
+func listBounds(args [2]int, n int) (int, int) {
+	start, end := max(args[0], 0), args[1]
+	if end <= 0 || end > n {
+		end = n
+	}
+	if start > end {
+		start = end
+	}
+	return start, end
+}
+
+// indexFlags are the flags taking optional indices, along with how many
+// indices they take.
+var indexFlags = map[string]int{
+	"-d":        math.MaxInt,
+	"--delete":  math.MaxInt,
+	"-l":        2,
+	"--list":    2,
+	"-p":        2,
+	"--paste":   2,
+	"--pin":     1,
+	"--promote": 1,
+	"--edit":    1,
+	"--move":    2,
+	"--range":   2,
+}
+
+// normalizeArgs folds the indices passed as separate arguments into their
+// flag, e.g. `-d -1 -3` becomes `-d=-1,-3`. Since the indices are optional,
+// pflag would otherwise treat them as positional arguments, or as shorthand
+// flags when they are negative. Arguments naming a shorthand flag, like -0,
+// are never folded.
+func normalizeArgs(flagset *pflag.FlagSet, args []string) []string {
+	out := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(out, args[i:]...)
+		}
+
+		n, ok := indexFlags[arg]
+		var values []string
+		for ok && len(values) < n && i+1 < len(args) {
+			if _, err := strconv.Atoi(args[i+1]); err != nil || isShorthand(flagset, args[i+1]) {
+				break
+			}
+			i++
+			values = append(values, args[i])
+		}
+		if len(values) > 0 {
+			arg += "=" + strings.Join(values, ",")
+		}
+		out = append(out, arg)
+	}
+	return out
+}
+
+// isShorthand reports whether the argument is a registered shorthand flag.
+func isShorthand(flagset *pflag.FlagSet, arg string) bool {
+	name, ok := strings.CutPrefix(arg, "-")
+	return ok && len(name) == 1 && flagset.ShorthandLookup(name) != nil
+}
+
+// latestIndex is what a bare -p is set to. It selects the latest item like 0
+// does, but tells apart not passing an index from explicitly passing 0.
+const latestIndex = "latest"
+
+// indexSlice is an int slice flag, compatible with GetIntSlice, that also
+// accepts latestIndex.
+type indexSlice struct {
+	values   []int
+	changed  bool
+	explicit bool // Whether any index was passed, rather than latestIndex
+}
+
+func (s *indexSlice) Set(value string) error {
+	values := []int{0}
+	if value != latestIndex {
+		values = values[:0]
+		for _, v := range strings.Split(value, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil {
+				return err
+			}
+			values = append(values, n)
+		}
+		s.explicit = true
+	}
+
+	// Like pflag's own slices, repeating the flag appends to it
+	if s.changed {
+		s.values = append(s.values, values...)
+	} else {
+		s.values = values
+	}
+	s.changed = true
+	return nil
+}
+
+func (s *indexSlice) String() string {
+	out := make([]string, len(s.values))
+	for i, v := range s.values {
+		out[i] = strconv.Itoa(v)
+	}
+	return "[" + strings.Join(out, ",") + "]"
+}
+
+func (s *indexSlice) Type() string {
+	return "intSlice"
+}
+
+// explicitIndex reports whether an index was passed to the flag, as opposed
+// to the flag being passed bare or not at all.
+func explicitIndex(flagset *pflag.FlagSet, name string) bool {
+	s, ok := flagset.Lookup(name).Value.(*indexSlice)
+	return ok && s.explicit
+}
+
+// operationFlags select what clip does, only one of them can be passed at a
+// time. The order parse checks them in only matters if that check is bypassed.
+var operationFlags = []string{
+	"version", "delete-all", "delete", "import", "export", "namespaces", "pick",
+	"where", "completion", "get-hash", "first", "last", "undo", "stats", "count", "duplicates", "dedup", "edit", "open",
+	"move", "promote", "trim", "pop", "pin", "list", "find", "paste", "watch", "serve", "add-file", "add-json",
+	"from-clipboard", "from-tmux",
+}
+
+// conflictingOperations fails if more than one operation flag was passed.
+// --find narrows down --list, so the two can be combined.
+func conflictingOperations(flagset *pflag.FlagSet) error {
+	var names []string
+	for _, name := range operationFlags {
+		if flagset.Changed(name) && !(name == "find" && flagset.Changed("list")) {
+			names = append(names, "--"+name)
+		}
+	}
+	if len(names) > 1 {
+		return usageErrorf("conflicting operations %s, pass only one of them", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+func parse(flagset *pflag.FlagSet) (Flags, error) {
+	var flags Flags
+	flags.Operation = OpHelp // Default operation
+
+	if err := conflictingOperations(flagset); err != nil {
+		return flags, err
+	}
+
+	emptyArg0 := true
+	if flagset.NArg() > 0 {
+		// NOTE: No need to allow empty space to be copied
+		// Instead we could utilize this to paste into an empty space in nvim.
+		emptyArg0 = strings.TrimSpace(flagset.Arg(0)) == ""
+		if !emptyArg0 {
+			// Try again but unescaped
+			emptyArg0 = strings.TrimSpace(unescape(flagset.Arg(0))) == ""
+		}
+	}
+
+	if flagset.Changed("version") {
+		v, err := flagset.GetBool("version")
+		if err != nil {
+			return flags, err
+		}
+		if v {
+			flags.Operation = OpVersion
+		}
+		jsonOut, err := flagset.GetBool("json")
+		if err != nil {
+			return flags, err
+		}
+		flags.JSON = jsonOut
+	} else if flagset.Changed("delete-all") {
+		d, err := flagset.GetBool("delete-all")
+		if err != nil {
+			return flags, err
+		}
+		if d {
+			flags.Operation = OpDeleteAll
+		}
+		flags.DryRun = flagset.Changed("dry-run")
+	} else if flagset.Changed("delete") {
+		indices, err := flagset.GetIntSlice("delete")
+		if err != nil {
+			return flags, err
+		}
+		if len(indices) == 0 || (len(indices) == 1 && indices[0] == 0) {
+			flags.Operation = OpDelete
+		} else {
+			flags.Operation = OpDelete
+			flags.DeleteIndices = indices
+		}
+		match, err := flagset.GetString("match")
+		if err != nil {
+			return flags, err
+		}
+		hashes, err := flagset.GetStringSlice("hash")
+		if err != nil {
+			return flags, err
+		}
+		regex, err := flagset.GetBool("regex")
+		if err != nil {
+			return flags, err
+		}
+		ignoreCase, err := flagset.GetBool("ignore-case")
 		if err != nil {
-			return flags, fmt.Errorf("error reading piped input: %w", err)
+			return flags, err
+		}
+		flags.Match = match
+		flags.Regex = regex
+		flags.IgnoreCase = ignoreCase
+		flags.Hashes = hashes
+		flags.Silent = flagset.Changed("silent")
+		flags.DryRun = flagset.Changed("dry-run")
+
+		// NOTE: Even 0, since only the match or hashes would be deleted
+		explicit := explicitIndex(flagset, "delete")
+		if explicit && (match != "" || len(hashes) > 0) {
+			return flags, usageErrorf("indices cannot be combined with --match or --hash")
+		}
+		if match != "" && len(hashes) > 0 {
+			return flags, usageErrorf("--match cannot be combined with --hash")
 		}
 
-		if pipeInput != "" {
-			// TODO: If an exact match isn't found this should do a prefix match.
-			// TODO: In the future this should take into consideration list columns;
-			// if / when we support truncating lists this will break unless we do
-			// something to prevent that, like prefix matches, or adding an idx column
-			// to the list output.
+		if flagset.Changed("oldest") {
+			if explicit || match != "" || len(hashes) > 0 {
+				return flags, usageErrorf("--oldest cannot be combined with indices, --match or --hash")
+			}
+			flags.Oldest = true
+			flags.DeleteIndices = []int{-1}
+		}
 
-			// NOTE: Since we escape newlines in the list output, let's unescape them
-			unescaped := strings.ReplaceAll(pipeInput, "\\n", "\n")
-			hash := app.hash(unescaped)
-			idx, exists := app.index[hash]
-			if !exists && pipeInput != unescaped {
-				hash = app.hash(pipeInput)
-				idx, exists = app.index[hash]
+		if flagset.Changed("range") {
+			bounds, err := flagset.GetIntSlice("range")
+			if err != nil {
+				return flags, err
 			}
-			if !exists {
-				return flags, nil
+			if len(bounds) != 2 {
+				return flags, usageErrorf("--range takes two indices, the first and last item to delete")
 			}
-			if paste != 0 {
-				// WARN: This ignores that the user could have explicitly set 0
-				return flags, fmt.Errorf("piped input cannot be used when pasting an item by index")
+			if explicit || match != "" || len(hashes) > 0 {
+				return flags, usageErrorf("--range cannot be combined with indices, --match, --hash or --oldest")
 			}
+			flags.DeleteIndices = bounds
+			flags.DeleteRange = true
+		}
+	} else if flagset.Changed("import") {
+		path, err := flagset.GetString("import")
+		if err != nil {
+			return flags, err
+		}
 
-			// we need to invert the index (len - idx - 1)
-			flags.PasteIndex = len(app.Items) - idx - 1
+		// NOTE: Read it before the data file is locked, the export may be piped
+		// from another clip, e.g. `clip --export | clip --ns=backup --import=-`
+		var data []byte
+		if path == "-" {
+			data, err = readLimited(os.Stdin, maxBulkBytes)
+		} else {
+			data, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return flags, withCode(codeStorage, fmt.Errorf("error reading import: %w", err))
+		}
+		flags.Operation = OpImport
+		flags.ImportData = data
+		flags.Silent = flagset.Changed("silent")
+	} else if flagset.Changed("export") {
+		flags.Operation = OpExport
+	} else if flagset.Changed("namespaces") {
+		flags.Operation = OpNamespaces
+	} else if flagset.Changed("pick") {
+		showSensitive, err := flagset.GetBool("show-sensitive")
+		if err != nil {
+			return flags, err
+		}
+		flags.Operation = OpPick
+		flags.ShowSensitive = showSensitive
+	} else if flagset.Changed("where") {
+		flags.Operation = OpWhere
+	} else if flagset.Changed("completion") {
+		shell, err := flagset.GetString("completion")
+		if err != nil {
+			return flags, err
+		}
+		flags.Operation = OpCompletion
+		flags.Shell = shell
+	} else if flagset.Changed("get-hash") {
+		hash, err := flagset.GetString("get-hash")
+		if err != nil {
+			return flags, err
+		}
+		flags.Operation = OpGetHash
+		flags.GetHash = hash
+	} else if flagset.Changed("first") {
+		flags.Operation = OpFirst
+	} else if flagset.Changed("last") {
+		flags.Operation = OpLast
+	} else if flagset.Changed("undo") {
+		flags.Operation = OpUndo
+	} else if flagset.Changed("stats") {
+		flags.Operation = OpStats
+	} else if flagset.Changed("count") {
+		flags.Operation = OpCount
+	} else if flagset.Changed("open") {
+		flags.Operation = OpOpen
+	} else if flagset.Changed("duplicates") {
+		jsonOut, err := flagset.GetBool("json")
+		if err != nil {
+			return flags, err
+		}
+		flags.Operation = OpDuplicates
+		flags.JSON = jsonOut
+	} else if flagset.Changed("dedup") {
+		flags.Operation = OpDedup
+		flags.Silent = flagset.Changed("silent")
+	} else if flagset.Changed("edit") {
+		idx, err := flagset.GetInt("edit")
+		if err != nil {
+			return flags, err
+		}
+		flags.Operation = OpEdit
+		flags.EditIndex = idx
+	} else if flagset.Changed("move") {
+		args, err := flagset.GetIntSlice("move")
+		if err != nil {
+			return flags, err
+		}
+		if len(args) != 2 {
+			return flags, usageErrorf("--move takes two indices, the item to move and where to move it")
+		}
+		flags.Operation = OpMove
+		flags.MoveArgs = [2]int{args[0], args[1]}
+	} else if flagset.Changed("pop") {
+		flags.Operation = OpPop
+		flags.Oldest = flagset.Changed("oldest")
+	} else if flagset.Changed("promote") {
+		idx, err := flagset.GetInt("promote")
+		if err != nil {
+			return flags, err
+		}
+		flags.Operation = OpPromote
+		flags.PromoteIndex = idx
+		flags.Silent = flagset.Changed("silent")
+	} else if flagset.Changed("trim") {
+		n, err := flagset.GetInt("trim")
+		if err != nil {
+			return flags, err
+		}
+		if n < 0 {
+			return flags, usageErrorf("--trim must not be negative")
+		}
+		flags.Operation = OpTrim
+		flags.TrimCount = n
+		flags.Silent = flagset.Changed("silent")
+		flags.DryRun = flagset.Changed("dry-run")
+	} else if flagset.Changed("pin") {
+		idx, err := flagset.GetInt("pin")
+		if err != nil {
+			return flags, err
+		}
+		flags.Operation = OpPin
+		flags.PinIndex = idx
+	} else if flagset.Changed("list") {
+		listArgs, err := flagset.GetIntSlice("list")
+		if err != nil {
+			return flags, err
+		}
+		if len(listArgs) == 0 {
+			flags.Operation = OpList
+		} else if len(listArgs) == 1 {
+			// A single argument is a limit, i.e. the range [0, limit)
+			flags.Operation = OpList
+			flags.ListArgs[1] = listArgs[0]
+		} else if len(listArgs) == 2 {
+			flags.Operation = OpList
+			flags.ListArgs[0] = listArgs[0]
+			flags.ListArgs[1] = listArgs[1]
+		} else {
+			return flags, usageErrorf("invalid number of arguments for list operation")
+		}
+	} else if flagset.Changed("find") {
+		flags.Operation = OpFind
+	} else if flagset.Changed("paste") || flagset.Changed("oldest") {
+		flags.Operation = OpPaste
+		paste, err := flagset.GetIntSlice("paste")
+		if err != nil {
+			return flags, err
+		}
+		switch len(paste) {
+		case 0:
+		case 1:
+			flags.PasteIndex = paste[0]
+			flags.PasteIndexSet = explicitIndex(flagset, "paste")
+		case 2:
+			flags.PasteIndex, flags.PasteEnd, flags.PasteRange = paste[0], paste[1], true
+		default:
+			return flags, usageErrorf("--paste takes an index, or the first and last index of a range")
+		}
+		separator, err := flagset.GetString("separator")
+		if err != nil {
+			return flags, err
+		}
+		flags.Separator = separator
+		match, err := flagset.GetString("match")
+		if err != nil {
+			return flags, err
+		}
+		if match != "" && (flags.PasteIndexSet || flags.PasteRange || flagset.Changed("oldest")) {
+			return flags, usageErrorf("--match cannot be combined with an index")
+		}
+		regex, err := flagset.GetBool("regex")
+		if err != nil {
+			return flags, err
+		}
+		ignoreCase, err := flagset.GetBool("ignore-case")
+		if err != nil {
+			return flags, err
+		}
+		flags.Match = match
+		flags.Regex = regex
+		flags.IgnoreCase = ignoreCase
+		if flagset.Changed("oldest") {
+			if flags.PasteIndexSet || flags.PasteRange {
+				return flags, usageErrorf("--oldest cannot be combined with an index")
+			}
+			flags.Oldest = true
+			flags.PasteIndex = -1
+		}
+		// NOTE: Support piping back fzf of list output
+		// Ex: `clip -l | fzf | clip -p`
+		pipeInput, err := getPipeInput()
+		if err != nil {
+			return flags, withCode(codePipeInput, fmt.Errorf("error reading piped input: %w", err))
+		}
+
+		// NOTE: The input is read here, before the data file is locked, since the
+		// process writing to the pipe may be another clip waiting on that lock.
+		// It is matched against the items in handle.
+		flags.PipeInput = pipeInput
+	} else if flagset.Changed("watch") {
+		interval, err := flagset.GetDuration("watch-interval")
+		if err != nil {
+			return flags, err
+		}
+		if interval <= 0 {
+			return flags, usageErrorf("the watch interval must be positive")
+		}
+		flags.Operation = OpWatch
+		flags.WatchInterval = interval
+		flags.Silent = flagset.Changed("silent")
+	} else if flagset.Changed("serve") {
+		addr, err := flagset.GetString("serve")
+		if err != nil {
+			return flags, err
 		}
+		if addr == "" {
+			return flags, usageErrorf("--serve requires an address to listen on, e.g. :8099")
+		}
+		flags.Operation = OpServe
+		flags.Addr = addr
+	} else if flagset.Changed("add-file") {
+		path, err := flagset.GetString("add-file")
+		if err != nil {
+			return flags, err
+		}
+
+		// NOTE: Read before the data file is locked, like piped input
+		var data []byte
+		if path == "-" {
+			data, err = readLimited(os.Stdin, maxPipeBytes)
+		} else {
+			data, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return flags, withCode(codeStorage, fmt.Errorf("error reading file to add: %w", err))
+		}
+		flags.Operation = OpAdd
+		if strings.TrimSpace(string(data)) != "" {
+			flags.Text = string(data)
+		}
+		flags.Silent = flagset.Changed("silent")
+	} else if flagset.Changed("add-json") {
+		// NOTE: Parsed before the data file is locked, so malformed input never
+		// touches the history
+		data, err := readLimited(os.Stdin, maxBulkBytes)
+		if err != nil {
+			return flags, withCode(codePipeInput, fmt.Errorf("error reading input: %w", err))
+		}
+		var texts []string
+		if err := json.Unmarshal(data, &texts); err != nil {
+			return flags, withCode(codePipeInput, fmt.Errorf("invalid JSON, expected an array of strings: %w", err))
+		}
+		if texts == nil {
+			texts = []string{} // A null array adds nothing
+		}
+		flags.Operation = OpAdd
+		flags.Text = string(data)
+		flags.Texts = texts
+		flags.Silent = flagset.Changed("silent")
+	} else if flagset.Changed("from-clipboard") {
+		flags.Operation = OpAdd
+		flags.FromClipboard = true
+		flags.Silent = flagset.Changed("silent")
+	} else if flagset.Changed("from-tmux") {
+		if !inTmux() {
+			return flags, errNoTmux
+		}
+		flags.Operation = OpAdd
+		flags.FromTmux = true
+		flags.Silent = flagset.Changed("silent")
 	} else if flagset.NArg() == 1 && !emptyArg0 {
 		flags.Operation = OpAdd
 		flags.Text = flagset.Arg(0)
@@ -464,13 +2408,12 @@ func (app *application) parse(flagset *pflag.FlagSet) (Flags, error) {
 			flags.Silent = true
 		}
 	} else if flagset.NArg() > 1 {
-		log.Println("Invalid number of arguments")
-		return flags, pflag.ErrHelp
+		return flags, usageErrorf("invalid number of arguments")
 	} else {
 		// Now this could be either a piped input to a copy, otherwise it's a paste
 		pipeInput, err := getPipeInput()
 		if err != nil {
-			return flags, err
+			return flags, withCode(codePipeInput, err)
 		}
 
 		if pipeInput != "" {
@@ -482,14 +2425,263 @@ func (app *application) parse(flagset *pflag.FlagSet) (Flags, error) {
 		} else if emptyArg0 {
 			flags.Operation = OpPaste
 		} else {
-			log.Println("Invalid operation, please provide a valid command or input")
-			return flags, pflag.ErrHelp
+			return flags, usageErrorf("invalid operation, please provide a valid command or input")
+		}
+	}
+
+	if flags.Operation == OpPaste || flags.Operation == OpPop || flags.Operation == OpGetHash || flags.Operation == OpFirst || flags.Operation == OpLast || flags.Operation == OpPick {
+		newline, err := flagset.GetBool("newline")
+		if err != nil {
+			return flags, err
+		}
+		peek, err := flagset.GetBool("peek")
+		if err != nil {
+			return flags, err
+		}
+		toClipboard, err := flagset.GetBool("to-clipboard")
+		if err != nil {
+			return flags, err
+		}
+		toTmux, err := flagset.GetBool("to-tmux")
+		if err != nil {
+			return flags, err
+		}
+		if toTmux && !inTmux() {
+			return flags, errNoTmux
+		}
+		flags.Newline = newline
+		flags.Peek = peek
+		flags.ToClipboard = toClipboard
+		flags.ToTmux = toTmux
+
+		out, err := flagset.GetString("out")
+		if err != nil {
+			return flags, err
+		}
+		if out != "-" {
+			flags.Out = out
+		}
+
+		template, err := flagset.GetString("template")
+		if err != nil {
+			return flags, err
+		}
+		if flagset.Changed("template") && strings.Count(template, "%s") != 1 {
+			return flags, usageErrorf("the template must contain exactly one %%s, got %q", template)
+		}
+		flags.Template = template
+
+		withHash, err := flagset.GetBool("with-hash")
+		if err != nil {
+			return flags, err
+		}
+		if withHash && flags.PasteRange {
+			return flags, usageErrorf("--with-hash cannot be combined with a range")
+		}
+		flags.WithHash = withHash
+
+		strip, err := flagset.GetString("strip")
+		if err != nil {
+			return flags, err
+		}
+		stripRegex, err := flagset.GetString("strip-regex")
+		if err != nil {
+			return flags, err
+		}
+		if strip != "" && stripRegex != "" {
+			return flags, usageErrorf("--strip cannot be combined with --strip-regex")
+		} else if stripRegex != "" {
+			if _, err := stripper(stripRegex, true); err != nil {
+				return flags, usageErrorf("invalid --strip-regex expression: %v", err)
+			}
+			strip = stripRegex
+		}
+		flags.Strip = strip
+		flags.StripRegex = stripRegex != ""
+	}
+
+	file, err := flagset.GetString("file")
+	if err != nil {
+		return flags, err
+	}
+	flags.File = file
+
+	raw, err := flagset.GetBool("raw")
+	if err != nil {
+		return flags, err
+	}
+	flags.Raw = raw
+
+	if flags.Operation == OpAdd {
+		lines, err := flagset.GetBool("lines")
+		if err != nil {
+			return flags, err
+		}
+		sensitive, err := flagset.GetBool("sensitive")
+		if err != nil {
+			return flags, err
+		}
+		confirm, err := flagset.GetBool("confirm")
+		if err != nil {
+			return flags, err
+		}
+		source, err := flagset.GetString("source")
+		if err != nil {
+			return flags, err
+		}
+		if flags.Texts != nil && (lines || flagset.Changed("base64")) {
+			return flags, usageErrorf("--add-json cannot be combined with --lines or --base64")
+		}
+		flags.Lines = lines
+		flags.Sensitive = sensitive
+		flags.Confirm = confirm
+		flags.Source = source
+	}
+
+	if flags.Operation == OpAdd || flags.Operation == OpPaste || flags.Operation == OpPop || flags.Operation == OpGetHash || flags.Operation == OpFirst || flags.Operation == OpLast {
+		b64, err := flagset.GetBool("base64")
+		if err != nil {
+			return flags, err
+		}
+		if b64 && flags.Lines {
+			return flags, usageErrorf("--base64 cannot be combined with --lines")
+		}
+		flags.Base64 = b64
+	}
+
+	ns, err := flagset.GetString("ns")
+	if err != nil {
+		return flags, err
+	}
+	if ns != defaultNamespace && !validNamespace.MatchString(ns) {
+		return flags, usageErrorf("invalid namespace %q", ns)
+	}
+	flags.Namespace = ns
+
+	tags, err := flagset.GetStringSlice("tag")
+	if err != nil {
+		return flags, err
+	}
+	flags.Tags = tags
+
+	// Listing can be narrowed down with the same query used by find
+	if flags.Operation == OpList || flags.Operation == OpFind {
+		query, err := flagset.GetString("find")
+		if err != nil {
+			return flags, err
+		}
+		regex, err := flagset.GetBool("regex")
+		if err != nil {
+			return flags, err
+		}
+		jsonOut, err := flagset.GetBool("json")
+		if err != nil {
+			return flags, err
+		}
+		ignoreCase, err := flagset.GetBool("ignore-case")
+		if err != nil {
+			return flags, err
+		}
+		flags.Query = query
+		flags.Regex = regex
+		flags.IgnoreCase = ignoreCase
+		null, err := flagset.GetBool("null")
+		if err != nil {
+			return flags, err
+		}
+		numbered, err := flagset.GetBool("numbered")
+		if err != nil {
+			return flags, err
+		}
+		size, err := flagset.GetBool("size")
+		if err != nil {
+			return flags, err
+		}
+		showSensitive, err := flagset.GetBool("show-sensitive")
+		if err != nil {
+			return flags, err
+		}
+		flags.ShowSensitive = showSensitive
+		chronological, err := flagset.GetBool("chronological")
+		if err != nil {
+			return flags, err
+		}
+		flags.Chronological = chronological
+		uniquePrefix, err := flagset.GetInt("unique-prefix")
+		if err != nil {
+			return flags, err
+		}
+		if uniquePrefix < 0 {
+			return flags, usageErrorf("--unique-prefix must not be negative")
+		}
+		flags.UniquePrefix = uniquePrefix
+		hashPrefix, err := flagset.GetString("hash-prefix")
+		if err != nil {
+			return flags, err
+		}
+		if flagset.Changed("hash-prefix") && hashPrefix == "" {
+			return flags, usageErrorf("--hash-prefix must not be empty")
+		}
+		flags.HashPrefix = hashPrefix
+		flags.Numbered = numbered
+		flags.Size = size
+		flags.JSON = jsonOut
+		flags.Null = null
+
+		// NOTE: Truncate to the terminal unless the width is explicitly set, e.g.
+		// `clip -l --width=80 | fzf` to also truncate piped output
+		if flagset.Changed("width") {
+			width, err := flagset.GetInt("width")
+			if err != nil {
+				return flags, err
+			}
+			flags.Width = max(width, 0)
+		} else {
+			flags.Width = terminalWidth()
+		}
+
+		// NOTE: See https://no-color.org
+		noColor, err := flagset.GetBool("no-color")
+		if err != nil {
+			return flags, err
 		}
+		flags.Color = !noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
+	}
+
+	// NOTE: Ignoring it would really delete what was only meant to be previewed
+	if flagset.Changed("dry-run") && flags.Operation != OpDelete && flags.Operation != OpDeleteAll && flags.Operation != OpTrim {
+		return flags, usageErrorf("--dry-run can only be combined with --delete, --delete-all or --trim")
+	}
+	if flagset.Changed("range") && !flagset.Changed("delete") {
+		return flags, usageErrorf("--range can only be combined with --delete")
+	}
+	if flagset.Changed("hash") && !flagset.Changed("delete") {
+		return flags, usageErrorf("--hash can only be combined with --delete")
+	}
+	if flagset.Changed("match") && !flagset.Changed("delete") && !flagset.Changed("paste") {
+		return flags, usageErrorf("--match can only be combined with --delete or --paste")
 	}
 
 	return flags, nil
 }
 
+// stdinTimeout is how long getPipeInput waits for piped input before treating
+// it as empty, 0 to wait until the pipe is closed. It is not on by default as
+// piping from an interactive filter, e.g. `clip -l | fzf | clip -p`, can
+// legitimately take a while.
+var stdinTimeout time.Duration
+
+// maxPipeBytes is the most piped input getPipeInput reads, MaxItemBytes if it
+// is set, so huge input fails instead of exhausting memory.
+var maxPipeBytes = 64 << 20
+
+// maxBulkBytes is the most input --add-json and --import read from stdin.
+// Their items are limited one by one, so MaxItemBytes doesn't bound the whole.
+const maxBulkBytes = 64 << 20
+
+// errPipeTooLarge is returned when piped input exceeds maxPipeBytes.
+var errPipeTooLarge = errors.New("piped input is too large")
+
 func getPipeInput() (string, error) {
 	// Wait for out to be done / flushed
 	//if err := os.Stdout.Sync(); err != nil {
@@ -501,7 +2693,7 @@ func getPipeInput() (string, error) {
 		return "", fmt.Errorf("error reading pipe status: %w", err)
 	}
 	if (info.Mode() & os.ModeCharDevice) == 0 {
-		data, err := io.ReadAll(os.Stdin)
+		data, err := readStdin(stdinTimeout)
 		if err != nil {
 			return "", fmt.Errorf("error reading from pipe: %w", err)
 		}
@@ -511,9 +2703,9 @@ func getPipeInput() (string, error) {
 			return "", nil
 		}
 
-		// As a special case, if after we unescape newlines, and trim, we have
+		// As a special case, if after we unescape, and trim, we have
 		// nothing, we return nothing
-		if strings.TrimSpace(strings.ReplaceAll(string(data), "\\n", "\n")) == "" {
+		if strings.TrimSpace(unescape(string(data))) == "" {
 			return "", nil
 		}
 
@@ -522,6 +2714,51 @@ func getPipeInput() (string, error) {
 	return "", nil // No input from pipe
 }
 
+// readStdin reads stdin until it is closed, or returns nothing if that takes
+// longer than the timeout, e.g. when an open pipe is never written to. A zero
+// timeout waits indefinitely.
+func readStdin(timeout time.Duration) ([]byte, error) {
+	if timeout <= 0 {
+		return readLimited(os.Stdin, maxPipeBytes)
+	}
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	// NOTE: Buffered so the reader doesn't leak blocked on the send, although
+	// it may stay blocked on the read until the process exits
+	done := make(chan result, 1)
+	go func() {
+		data, err := readLimited(os.Stdin, maxPipeBytes)
+		done <- result{data, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.data, r.err
+	case <-time.After(timeout):
+		Debugf("No piped input after %s, ignoring stdin", timeout)
+		return nil, nil
+	}
+}
+
+// readLimited reads r until EOF, failing once more than limit bytes were read.
+// Like for MaxItemBytes, surrounding whitespace doesn't count towards the
+// limit, e.g. the newline echo ends its output with, up to 2KiB of it, so at
+// most that much more than the limit is ever read.
+func readLimited(r io.Reader, limit int) ([]byte, error) {
+	const slack = 1 << 10
+	data, err := io.ReadAll(io.LimitReader(r, int64(limit+2*slack)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > limit+2*slack || len(strings.TrimSpace(string(data))) > limit {
+		return nil, fmt.Errorf("%w, the limit is %s", errPipeTooLarge, store.Plural(limit, "byte"))
+	}
+	return data, nil
+}
+
 func Out(s string) {
 	fmt.Print(s)
 }
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+)
+
+// Escape sequences switching to the alternate screen and back, hiding the
+// cursor while picking.
+const (
+	enterScreen = "\x1b[?1049h\x1b[?25l"
+	leaveScreen = "\x1b[?25h\x1b[?1049l"
+	clearScreen = "\x1b[H\x1b[2J"
+	reverse     = "\x1b[7m"
+)
+
+// pickHelp is the header of the picker.
+const pickHelp = "↑/↓ select, enter paste, d delete, q quit"
+
+// picker is the state of the interactive selector of --pick.
+type picker struct {
+	app   *application
+	flags Flags
+	tty   *os.File
+	items []int // Indices of the listed items, newest first
+	// selected is the position of the selected item in items, top the
+	// position of the first one on screen.
+	selected, top int
+}
+
+// pick lets the user select an item, returning its index and whether one was
+// selected. Items can be deleted while picking. It falls back to a numbered
+// prompt when there is no terminal to draw the picker on.
+func (app *application) pick(flags Flags) (int, bool, error) {
+	// NOTE: Draw on the terminal itself, stdout is where the selected item goes
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return app.prompt(flags)
+	}
+	defer tty.Close()
+
+	restore, err := makeRaw(tty)
+	if err != nil {
+		return app.prompt(flags)
+	}
+
+	// Restore the terminal however picking ends, including on interrupts which
+	// would otherwise leave it in raw mode
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append([]os.Signal{os.Interrupt, syscall.SIGTERM}, resizeSignals...)...)
+	defer signal.Stop(signals)
+
+	fmt.Fprint(tty, enterScreen)
+	defer func() {
+		fmt.Fprint(tty, leaveScreen)
+		if err := restore(); err != nil {
+			Warnf("Failed to restore the terminal: %v", err)
+		}
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	keys := make(chan string)
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := tty.Read(buf)
+			if err != nil {
+				return
+			}
+			for _, key := range splitKeys(string(buf[:n])) {
+				select {
+				case keys <- key:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	p := &picker{app: app, flags: flags, tty: tty}
+	p.refresh()
+	for {
+		p.draw()
+
+		select {
+		case sig := <-signals:
+			if sig == os.Interrupt || sig == syscall.SIGTERM {
+				return 0, false, nil
+			}
+			// Resized, redraw
+		case key := <-keys:
+			switch key {
+			case "\x1b[A", "\x1bOA", "k", "\x10": // Up, k or ctrl-p
+				p.selected = max(p.selected-1, 0)
+			case "\x1b[B", "\x1bOB", "j", "\x0e": // Down, j or ctrl-n
+				p.selected = min(p.selected+1, max(len(p.items)-1, 0))
+			case "\r", "\n":
+				if len(p.items) > 0 {
+					return p.items[p.selected], true, nil
+				}
+			case "d":
+				// Deleting can't be saved in a read-only history
+				if len(p.items) > 0 && !app.ReadOnly() {
+					app.Remove(p.items[p.selected])
+					p.refresh()
+				}
+			case "q", "\x1b", "\x03": // q, escape or ctrl-c
+				return 0, false, nil
+			}
+		}
+	}
+}
+
+// splitKeys splits what was read from the terminal into key presses, since
+// keys pressed in quick succession are read at once.
+func splitKeys(s string) []string {
+	var keys []string
+	for s != "" {
+		n := 1
+		if len(s) >= 3 && (strings.HasPrefix(s, "\x1b[") || strings.HasPrefix(s, "\x1bO")) {
+			n = 3
+		} else if _, size := utf8.DecodeRuneInString(s); size > 1 {
+			n = size
+		}
+		keys = append(keys, s[:n])
+		s = s[n:]
+	}
+	return keys
+}
+
+// refresh collects the items to pick from, the same ones the list shows.
+func (p *picker) refresh() {
+	p.items = p.items[:0]
+	for i, item := range p.app.Range {
+		if item.Sensitive && !p.flags.ShowSensitive {
+			continue
+		}
+		p.items = append(p.items, i)
+	}
+	p.selected = max(min(p.selected, len(p.items)-1), 0)
+}
+
+// draw renders the items that fit on the terminal, scrolled so the selected
+// one is visible.
+func (p *picker) draw() {
+	cols, rows := ttySize(p.tty)
+	if cols <= 0 || rows <= 1 {
+		cols, rows = 80, 24
+	}
+
+	// The first row is taken by the header
+	height := rows - 1
+	if p.selected < p.top {
+		p.top = p.selected
+	} else if p.selected >= p.top+height {
+		p.top = p.selected - height + 1
+	}
+
+	n := p.app.Len()
+	width := len(strconv.Itoa(max(n-1, 0)))
+
+	var b strings.Builder
+	b.WriteString(clearScreen)
+	b.WriteString(truncate(pickHelp, cols))
+	if len(p.items) == 0 {
+		b.WriteString("\r\n" + truncate(errEmpty.Error(), cols))
+	}
+	for row := p.top; row < len(p.items) && row < p.top+height; row++ {
+		item := p.app.Get(p.items[row])
+		prefix := fmt.Sprintf("%*d ", width, n-p.items[row]-1)
+		if item.Pinned {
+			prefix += pinMarker
+		}
+
+		line := binaryLabel
+		if !item.Binary {
+			line = truncate(item.Data, cols-columns(prefix))
+		}
+
+		b.WriteString("\r\n")
+		if row == p.selected {
+			b.WriteString(reverse + prefix + line + colorReset)
+		} else {
+			b.WriteString(prefix + line)
+		}
+	}
+
+	if _, err := p.tty.WriteString(b.String()); err != nil {
+		Warnf("Failed to draw the picker: %v", err)
+	}
+}
+
+// prompt lists the items numbered on stderr and reads the index of the item
+// to select from stdin.
+func (app *application) prompt(flags Flags) (int, bool, error) {
+	n := app.Len()
+	width := len(strconv.Itoa(max(n-1, 0)))
+	for i, item := range app.Range {
+		if item.Sensitive && !flags.ShowSensitive {
+			continue
+		}
+		line := escape(item.Data)
+		if item.Binary {
+			line = binaryLabel
+		}
+		fmt.Fprintf(os.Stderr, "%*d\t%s\n", width, n-i-1, line)
+	}
+
+	fmt.Fprint(os.Stderr, "Paste which item? ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, false, fmt.Errorf("error reading selection: %w", err)
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return 0, false, nil // Nothing selected
+	}
+
+	idx, err := strconv.Atoi(line)
+	if err != nil {
+		return 0, false, usageErrorf("invalid index %q", line)
+	}
+	i, err := resolveIdx(idx, n)
+	if err != nil {
+		return 0, false, err
+	}
+	return i, true, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import "iter"
+
+// RingBuffer keeps the last Size values pushed to it, overwriting the oldest
+// value once full. The zero value holds nothing, use NewRingBuffer, or set
+// Size and leave Items to be allocated by the first Push.
+type RingBuffer[T any] struct {
+	Size  int // Capacity of the buffer
+	Items []T // Backing storage, grown to Size on Push
+	Start int // Position of the oldest value in Items
+	End   int // Position the next value is written to in Items
+	count int
+}
+
+// NewRingBuffer returns a buffer keeping the last size values.
+func NewRingBuffer[T any](size int) *RingBuffer[T] {
+	return &RingBuffer[T]{Size: size, Items: make([]T, max(size, 0))}
+}
+
+// Push appends the value, returning the oldest value if it was overwritten to
+// make room for it.
+func (rb *RingBuffer[T]) Push(value T) (T, bool) {
+	var evicted T
+	if rb.Size <= 0 {
+		return evicted, false
+	}
+	if len(rb.Items) < rb.Size {
+		rb.Items = append(rb.Items, make([]T, rb.Size-len(rb.Items))...)
+	}
+
+	full := rb.count == rb.Size
+	if full {
+		evicted = rb.Items[rb.Start]
+		rb.Start = (rb.Start + 1) % rb.Size
+	} else {
+		rb.count++
+	}
+	rb.Items[rb.End] = value
+	rb.End = (rb.End + 1) % rb.Size
+	return evicted, full
+}
+
+// Get returns the ith value, counting from the oldest one.
+func (rb *RingBuffer[T]) Get(i int) (T, bool) {
+	if i < 0 || i >= rb.count {
+		var zero T
+		return zero, false
+	}
+	return rb.Items[(rb.Start+i)%rb.Size], true
+}
+
+// Len returns the number of values in the buffer.
+func (rb *RingBuffer[T]) Len() int {
+	return rb.count
+}
+
+// All iterates over the values from the oldest to the newest, along with
+// their position as passed to Get.
+func (rb *RingBuffer[T]) All() iter.Seq2[int, T] {
+	return func(yield func(int, T) bool) {
+		for i := range rb.count {
+			if !yield(i, rb.Items[(rb.Start+i)%rb.Size]) {
+				return
+			}
+		}
+	}
+}
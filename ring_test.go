@@ -0,0 +1,125 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// values collects the values of the buffer, from the oldest to the newest.
+func values[T any](rb *RingBuffer[T]) []T {
+	var out []T
+	for _, v := range rb.All() {
+		out = append(out, v)
+	}
+	return out
+}
+
+func TestRingBufferPush(t *testing.T) {
+	rb := NewRingBuffer[int](3)
+	for i := 1; i <= 3; i++ {
+		if evicted, ok := rb.Push(i); ok {
+			t.Fatalf("Push(%d) evicted %d before the buffer was full", i, evicted)
+		}
+	}
+	if got := values(rb); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Fatalf("values = %v, want [1 2 3]", got)
+	}
+
+	// Every push past the size evicts the oldest value, wrapping around
+	for i, want := range []int{1, 2, 3, 4} {
+		evicted, ok := rb.Push(i + 4)
+		if !ok || evicted != want {
+			t.Fatalf("Push(%d) = %d, %v, want %d, true", i+4, evicted, ok, want)
+		}
+	}
+	if got := values(rb); !slices.Equal(got, []int{5, 6, 7}) {
+		t.Fatalf("values = %v, want [5 6 7]", got)
+	}
+	if rb.Len() != 3 {
+		t.Fatalf("Len = %d, want 3", rb.Len())
+	}
+}
+
+func TestRingBufferGet(t *testing.T) {
+	rb := NewRingBuffer[string](2)
+	if _, ok := rb.Get(0); ok {
+		t.Fatal("Get(0) on an empty buffer succeeded")
+	}
+
+	rb.Push("a")
+	rb.Push("b")
+	rb.Push("c")
+	tests := []struct {
+		i    int
+		want string
+		ok   bool
+	}{
+		{-1, "", false},
+		{0, "b", true},
+		{1, "c", true},
+		{2, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := rb.Get(tt.i)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Get(%d) = %q, %v, want %q, %v", tt.i, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRingBufferLen(t *testing.T) {
+	rb := NewRingBuffer[int](3)
+	for i, want := range []int{1, 2, 3, 3, 3} {
+		rb.Push(i)
+		if rb.Len() != want {
+			t.Fatalf("Len after %d pushes = %d, want %d", i+1, rb.Len(), want)
+		}
+	}
+}
+
+func TestRingBufferAllStopsEarly(t *testing.T) {
+	rb := NewRingBuffer[int](4)
+	for i := range 6 {
+		rb.Push(i)
+	}
+
+	var positions, got []int
+	for i, v := range rb.All() {
+		positions = append(positions, i)
+		got = append(got, v)
+		if len(got) == 2 {
+			break
+		}
+	}
+	if !slices.Equal(positions, []int{0, 1}) || !slices.Equal(got, []int{2, 3}) {
+		t.Fatalf("All yielded %v at %v, want [2 3] at [0 1]", got, positions)
+	}
+}
+
+func TestRingBufferLiteral(t *testing.T) {
+	rb := &RingBuffer[int]{Size: 2}
+	for i := range 3 {
+		rb.Push(i)
+	}
+	if got := values(rb); !slices.Equal(got, []int{1, 2}) {
+		t.Fatalf("values = %v, want [1 2]", got)
+	}
+}
+
+func TestRingBufferNoSize(t *testing.T) {
+	for _, size := range []int{0, -1} {
+		rb := NewRingBuffer[int](size)
+		if evicted, ok := rb.Push(1); ok {
+			t.Errorf("size %d: Push evicted %d", size, evicted)
+		}
+		if rb.Len() != 0 {
+			t.Errorf("size %d: Len = %d, want 0", size, rb.Len())
+		}
+		if _, ok := rb.Get(0); ok {
+			t.Errorf("size %d: Get(0) succeeded", size)
+		}
+		if got := values(rb); len(got) != 0 {
+			t.Errorf("size %d: All yielded %v", size, got)
+		}
+	}
+}
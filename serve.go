@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/almahoozi/clip/store"
+)
+
+// shutdownTimeout is how long in-flight requests get to finish once serving
+// is interrupted.
+const shutdownTimeout = 5 * time.Second
+
+// serve exposes the history read-only over HTTP until interrupted:
+// - GET /latest: the latest item the list shows
+// - GET /items: the list as JSON, the same as --list --json
+// - GET /item/{index}: the item at the index, counted the same way as --paste
+//
+// Sensitive items are left out of all of them, the same way the list leaves
+// them out. Every request must pass CLIP_SERVE_TOKEN as a bearer token. Like
+// watch, the history is only opened, and so locked, while handling a request.
+func serve(config Config, flags Flags) error {
+	if config.ServeToken == "" {
+		return errors.New("CLIP_SERVE_TOKEN must be set to serve the history")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /latest", func(w http.ResponseWriter, r *http.Request) {
+		serveItem(w, config, func(app *application) (int, error) {
+			for i, item := range app.Range {
+				if !item.Sensitive {
+					return i, nil
+				}
+			}
+			return 0, errEmpty
+		})
+	})
+	mux.HandleFunc("GET /items", func(w http.ResponseWriter, r *http.Request) {
+		serveItems(w, config)
+	})
+	mux.HandleFunc("GET /item/{index}", func(w http.ResponseWriter, r *http.Request) {
+		idx, err := strconv.Atoi(r.PathValue("index"))
+		if err != nil {
+			http.Error(w, "invalid index", http.StatusBadRequest)
+			return
+		}
+		serveItem(w, config, func(app *application) (int, error) {
+			return resolveIdx(idx, app.Len())
+		})
+	})
+
+	server := &http.Server{
+		Addr:              flags.Addr,
+		Handler:           authorize(config.ServeToken, mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+	Debugf("Serving the history on %s", flags.Addr)
+
+	select {
+	case err := <-errs:
+		return fmt.Errorf("error serving: %w", err)
+	case <-ctx.Done():
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("error shutting down: %w", err)
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("error serving: %w", err)
+	}
+	return nil
+}
+
+// authorize only passes on requests carrying the token.
+func authorize(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// openHistory opens the history for a request. If it can't be loaded, e.g.
+// while another process holds the lock, the request fails but serving goes
+// on.
+func openHistory(w http.ResponseWriter, config Config) (*application, bool) {
+	app, err := openApplication(config)
+	if errors.Is(err, store.ErrLockTimeout) {
+		Warnf("%v", err)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "the history is locked by another process", http.StatusServiceUnavailable)
+		return nil, false
+	} else if err != nil {
+		Errorf("%v", err)
+		http.Error(w, "error loading the history", http.StatusInternalServerError)
+		return nil, false
+	}
+	return app, true
+}
+
+// closeHistory closes the history opened for a request. The response is
+// written by then, so failures are only logged.
+func closeHistory(app *application) {
+	if err := app.Close(); err != nil {
+		Errorf("%v", err)
+	}
+}
+
+// serveItem writes the item at the index resolve returns, without reordering
+// the history.
+func serveItem(w http.ResponseWriter, config Config, resolve func(app *application) (int, error)) {
+	app, ok := openHistory(w, config)
+	if !ok {
+		return
+	}
+	defer closeHistory(app)
+
+	if app.Len() == 0 {
+		http.Error(w, errEmpty.Error(), http.StatusNotFound)
+		return
+	}
+	i, err := resolve(app)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	item := app.Get(i)
+	if item.Sensitive {
+		http.Error(w, "the item is sensitive", http.StatusForbidden)
+		return
+	}
+	data := []byte(item.Data)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if item.Binary {
+		if data, err = base64.StdEncoding.DecodeString(item.Data); err != nil {
+			http.Error(w, "error decoding binary item", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	if _, err := w.Write(data); err != nil {
+		Warnf("Failed to write response: %v", err)
+	}
+}
+
+// serveItems writes the list as JSON, leaving out sensitive items the same
+// way the list does.
+func serveItems(w http.ResponseWriter, config Config) {
+	app, ok := openHistory(w, config)
+	if !ok {
+		return
+	}
+	defer closeHistory(app)
+
+	entries, err := app.listEntries(Flags{Size: true})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		http.Error(w, "error encoding list", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(data); err != nil {
+		Warnf("Failed to write response: %v", err)
+	}
+}
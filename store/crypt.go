@@ -0,0 +1,99 @@
+package store
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Encrypted data files are laid out as: encMagic, salt, nonce, ciphertext.
+var encMagic = []byte("CLIPENC1")
+
+const (
+	saltSize = 16
+	// kdfIterations trades off brute force resistance against the latency of
+	// every invocation, which derives the key twice.
+	kdfIterations = 100_000
+)
+
+var (
+	// ErrNoKey is returned by Open when the data file is encrypted but no key
+	// is configured.
+	ErrNoKey = errors.New("the data file is encrypted, but no key is set")
+	// ErrBadKey is returned by Open when the configured key doesn't decrypt the
+	// data file.
+	ErrBadKey  = errors.New("failed to decrypt the data file, is the key correct?")
+	errEncData = errors.New("encrypted data file is truncated")
+)
+
+// encrypted reports whether the data was written by encrypt.
+func encrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encMagic)
+}
+
+// encrypt seals the data with AES-GCM using a key derived from the passphrase.
+func encrypt(passphrase string, data []byte) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("error generating salt: %w", err)
+	}
+
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+
+	header := slices.Concat(encMagic, salt, nonce)
+	return gcm.Seal(header, nonce, data, encMagic), nil
+}
+
+// decrypt opens data sealed by encrypt.
+func decrypt(passphrase string, data []byte) ([]byte, error) {
+	if passphrase == "" {
+		return nil, ErrNoKey
+	}
+
+	data = data[len(encMagic):]
+	if len(data) < saltSize {
+		return nil, errEncData
+	}
+	salt, data := data[:saltSize], data[saltSize:]
+
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errEncData
+	}
+	nonce, data := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+
+	plain, err := gcm.Open(nil, nonce, data, encMagic)
+	if err != nil {
+		return nil, ErrBadKey
+	}
+	return plain, nil
+}
+
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, kdfIterations, 32)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
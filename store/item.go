@@ -0,0 +1,623 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Item is an entry of the history. Items are shared with the Store, changes
+// made to them directly are only written when followed by Touch.
+type Item struct {
+	Data     string   `json:"d,omitempty"`
+	Hash     string   `json:"h,omitempty"`
+	Created  int64    `json:"c,omitempty"` // Unix seconds when the item was added
+	Accessed int64    `json:"a,omitempty"` // Unix seconds when a paste or promotion last moved the item to the front
+	Pinned   bool     `json:"p,omitempty"` // Pinned items survive clearing and eviction
+	Tags     []string `json:"t,omitempty"` // Labels to filter the items by
+	// Binary items hold base64 encoded bytes, since JSON strings can't hold
+	// arbitrary bytes, and are decoded when pasted.
+	Binary bool `json:"b,omitempty"`
+	// Sensitive items are meant to be left out of listings unless asked for.
+	Sensitive bool `json:"s,omitempty"`
+	// Source labels where the item came from, e.g. the command it was piped
+	// from. It isn't part of the hash, re-adding the same data from elsewhere
+	// only relabels the item.
+	Source string `json:"o,omitempty"`
+}
+
+// Size is the number of bytes of the data, decoded for binary items.
+func (item *Item) Size() int {
+	if item.Binary {
+		return base64.StdEncoding.DecodedLen(len(item.Data)) - strings.Count(item.Data, "=")
+	}
+	return len(item.Data)
+}
+
+// Tag adds the tags to the item, skipping the ones it already has. It reports
+// whether any tag was added.
+func (item *Item) Tag(tags ...string) bool {
+	added := false
+	for _, tag := range tags {
+		if !slices.Contains(item.Tags, tag) {
+			item.Tags = append(item.Tags, tag)
+			added = true
+		}
+	}
+	return added
+}
+
+// HasTags reports whether the item has all of the tags.
+func (item *Item) HasTags(tags []string) bool {
+	for _, tag := range tags {
+		if !slices.Contains(item.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// Hash returns the hash items are deduplicated by, normalized as configured.
+func (c Config) Hash(data string) string {
+	if c.NormalizeNewlines {
+		data = strings.ReplaceAll(data, "\r\n", "\n")
+	}
+	if c.Trim {
+		data = strings.TrimSpace(data)
+	}
+	if c.CaseInsensitive {
+		data = strings.ToLower(data)
+	}
+	hash := sha256.Sum256([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// Hash returns the hash of the data under the configuration of the store.
+func (s *Store) Hash(data string) string {
+	return s.config.Hash(data)
+}
+
+// hash returns the hash of an item's data. Binary data is hashed by its
+// base64 encoding as is, apart from text, so a binary item never collides
+// with a text item spelled like its encoding.
+func (s *Store) hash(data string, binary bool) string {
+	if !binary {
+		return s.Hash(data)
+	}
+	hash := sha256.Sum256([]byte("binary:" + data))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// CheckSize returns an error if the data is larger than Config.MaxItemBytes,
+// trimmed like the hash does, or alone larger than Config.MaxTotalBytes.
+// Binary items are checked by their decoded size.
+func (s *Store) CheckSize(data string, binary bool) error {
+	size := (&Item{Data: data, Binary: binary}).Size()
+	if s.config.MaxTotalBytes > 0 && size > s.config.MaxTotalBytes {
+		return fmt.Errorf("item of %d bytes exceeds the total limit of %d bytes", size, s.config.MaxTotalBytes)
+	}
+
+	if s.config.MaxItemBytes <= 0 {
+		return nil
+	}
+	if s.config.Trim {
+		size = (&Item{Data: strings.TrimSpace(data), Binary: binary}).Size()
+	}
+	if size > s.config.MaxItemBytes {
+		return fmt.Errorf("item of %d bytes exceeds the limit of %d bytes", size, s.config.MaxItemBytes)
+	}
+	return nil
+}
+
+// Add adds the data as the latest item and returns it. If the data already
+// exists the existing item is moved to the end instead, keeping its metadata,
+// unless Config.AllowDuplicates is set. Data exceeding the size limits is refused, see CheckSize.
+func (s *Store) Add(data string) (*Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.add(data, false)
+}
+
+// AddBinary adds the bytes as the latest item, base64 encoded, the same way
+// Add does.
+func (s *Store) AddBinary(data []byte) (*Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.add(base64.StdEncoding.EncodeToString(data), true)
+}
+
+func (s *Store) add(data string, binary bool) (*Item, error) {
+	if err := s.CheckSize(data, binary); err != nil {
+		return nil, err
+	}
+
+	hash := s.hash(data, binary)
+
+	// Duplicates are appended like new data, the index then maps the hash to
+	// the new item
+	idx, exists := s.index[hash]
+	exists = exists && !s.config.AllowDuplicates
+	if exists && idx == len(s.items)-1 {
+		// Item already exists and is the latest, do nothing
+		s.debugf("Item %s is already the latest", hash)
+		return s.items[idx], nil
+	} else if exists {
+		// Move it to the end, refreshing it as if it was newly copied
+		s.debugf("Item %s already exists, moving it to the front", hash)
+		item := s.items[idx]
+		s.size -= item.Size()
+		item.Data = data
+		item.Created = time.Now().Unix()
+		item.Sensitive = item.Sensitive || s.config.DetectSecrets && looksSecret(data)
+		s.size += item.Size()
+		s.change()
+		s.promote(idx)
+		s.evict()
+		return item, nil
+	}
+
+	item := &Item{Data: data, Hash: hash, Created: time.Now().Unix(), Binary: binary}
+	item.Sensitive = s.config.DetectSecrets && looksSecret(data)
+	s.items = append(s.items, item)
+	s.size += item.Size()
+	s.change()
+	s.index[hash] = len(s.items) - 1
+	s.evict()
+	return item, nil
+}
+
+// migrate upgrades the loaded data to the current schema version.
+func (s *Store) migrate() {
+	for s.version < schemaVersion {
+		migrations[s.version](s)
+		s.version++
+		s.change()
+	}
+}
+
+// rehash recomputes the hash of every item, since the stored hashes may have
+// been computed under different settings, or by older versions using SHA-1.
+// NOTE: It doesn't mark the store dirty, the settings may only hold for this
+// process, e.g. the CLI's --raw, and every load rehashes anyway.
+func (s *Store) rehash() {
+	for _, item := range s.items {
+		item.Hash = s.hash(item.Data, item.Binary)
+	}
+}
+
+// expire drops the items created longer than TTL ago, except pinned ones.
+// Items without a creation time predate timestamps and are kept.
+func (s *Store) expire() {
+	if s.config.TTL <= 0 && s.config.SensitiveTTL <= 0 {
+		return
+	}
+
+	now := time.Now()
+	cutoff := func(ttl time.Duration) int64 {
+		if ttl <= 0 {
+			return 0
+		}
+		return now.Add(-ttl).Unix()
+	}
+	itemCutoff, sensitiveCutoff := cutoff(s.config.TTL), cutoff(s.config.SensitiveTTL)
+
+	n := len(s.items)
+	s.items = slices.DeleteFunc(s.items, func(item *Item) bool {
+		if item.Pinned || item.Created == 0 {
+			return false
+		}
+		return item.Created < itemCutoff || item.Sensitive && item.Created < sensitiveCutoff
+	})
+	if expired := n - len(s.items); expired > 0 {
+		s.debugf("Expired %s", Plural(expired, "item"))
+		s.change()
+	}
+}
+
+// evict removes the oldest items until the history fits within MaxItems and
+// MaxTotalBytes. Pinned items and the latest item are never evicted.
+func (s *Store) evict() {
+	over := func(n, size int) bool {
+		return s.config.MaxItems > 0 && n > s.config.MaxItems ||
+			s.config.MaxTotalBytes > 0 && size > s.config.MaxTotalBytes
+	}
+	if !over(len(s.items), s.size) {
+		return
+	}
+
+	n := len(s.items)
+	kept := s.items[:0]
+	for i, item := range s.items {
+		if over(n, s.size) && !item.Pinned && i < len(s.items)-1 {
+			n--
+			s.size -= item.Size()
+			continue
+		}
+		kept = append(kept, item)
+	}
+	s.debugf("Evicted %s", Plural(len(s.items)-len(kept), "item"))
+	s.items = kept
+	s.change()
+	s.reindex(false)
+}
+
+// measure recomputes the combined size of the items, after changes too broad
+// to track one item at a time.
+func (s *Store) measure() {
+	s.size = 0
+	for _, item := range s.items {
+		s.size += item.Size()
+	}
+}
+
+// Get returns the item at the index, or nil if it is out of range.
+func (s *Store) Get(index int) *Item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if index < 0 || index >= len(s.items) {
+		return nil
+	}
+	return s.items[index]
+}
+
+// Len returns the number of items.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.items)
+}
+
+// List returns the items, oldest first. The slice is a copy, so it stays
+// valid while the history changes.
+func (s *Store) List() []*Item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.items)
+}
+
+// Range calls yield for every item, newest first, with its index, until yield
+// returns false. It can be ranged over, e.g. `for i, item := range s.Range`.
+// It ranges over a snapshot of the history, like List, so the indices are
+// those from before any change made while ranging.
+func (s *Store) Range(yield func(index int, item *Item) bool) {
+	s.mu.Lock()
+	items := slices.Clone(s.items)
+	s.mu.Unlock()
+
+	for i := len(items) - 1; i >= 0; i-- {
+		if !yield(i, items[i]) {
+			return
+		}
+	}
+}
+
+// Index returns the index of the item with the hash, if there is one.
+func (s *Store) Index(hash string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx, exists := s.index[hash]
+	return idx, exists
+}
+
+// Touch marks the history as changed, so Close writes the changes made to
+// its items directly.
+func (s *Store) Touch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.change()
+	s.measure()
+}
+
+// change marks the history as changed, to be written by Close.
+func (s *Store) change() {
+	s.dirty = true
+	s.revision++
+}
+
+// Revision counts the changes to the history since Open, including those
+// made while loading it and undoing, so callers can tell whether an operation
+// changed it. Unlike whether Close writes, Discard doesn't reset it.
+func (s *Store) Revision() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.revision
+}
+
+// Discard drops the changes made since Open, or the last Undo, from being
+// written by Close. The items in memory keep them.
+func (s *Store) Discard() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dirty = false
+}
+
+// Clear removes all items except the pinned ones.
+func (s *Store) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.items)
+	s.items = slices.DeleteFunc(s.items, func(item *Item) bool {
+		return !item.Pinned
+	})
+	if len(s.items) != n {
+		s.change()
+	}
+	s.reindex(false)
+	s.measure()
+}
+
+// Truncate keeps the n latest unpinned items, and every pinned one, removing
+// the older ones. It returns how many items were removed.
+func (s *Store) Truncate(n int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := make([]*Item, 0, len(s.items))
+	for i := len(s.items) - 1; i >= 0; i-- {
+		if item := s.items[i]; item.Pinned || n > 0 {
+			if !item.Pinned {
+				n--
+			}
+			kept = append(kept, item)
+		}
+	}
+	slices.Reverse(kept)
+
+	removed := len(s.items) - len(kept)
+	s.items = kept
+	if removed > 0 {
+		s.change()
+	}
+	s.reindex(false)
+	s.measure()
+	return removed
+}
+
+// recency is when the item was last added or moved to the front.
+func (item *Item) recency() int64 {
+	return max(item.Created, item.Accessed)
+}
+
+// newer reports whether the item at i is kept over the item at j sharing its
+// hash when collapsing them: the more recently added or pasted one, or the
+// later one in the history if that's a tie, e.g. for items predating
+// timestamps.
+func (s *Store) newer(i, j int) bool {
+	a, b := s.items[i].recency(), s.items[j].recency()
+	if a != b {
+		return a > b
+	}
+	return i > j
+}
+
+// reindex maps every hash to the index of its latest item, the one nearest
+// the front. It returns how many items share their hash with a later one.
+// With collapse those are removed instead, keeping the newer item of each
+// group, which takes over the pins and tags of the others.
+func (s *Store) reindex(collapse bool) int {
+	s.index = make(map[string]int)
+	collisions := 0
+	for i, item := range s.items {
+		if _, exists := s.index[item.Hash]; exists {
+			collisions++
+		}
+		s.index[item.Hash] = i
+	}
+	if !collapse || collisions == 0 {
+		return collisions
+	}
+
+	keep := make(map[string]int)
+	for i, item := range s.items {
+		if j, exists := keep[item.Hash]; !exists || s.newer(i, j) {
+			keep[item.Hash] = i
+		}
+	}
+	kept := make([]*Item, 0, len(s.items)-collisions)
+	for i, item := range s.items {
+		if j := keep[item.Hash]; j != i {
+			s.debugf("Removing item %s, a duplicate of a newer item", item.Hash)
+			s.items[j].Pinned = s.items[j].Pinned || item.Pinned
+			s.items[j].Tag(item.Tags...)
+			continue
+		}
+		kept = append(kept, item)
+	}
+	s.items = kept
+	s.change()
+	s.measure()
+	s.reindex(false)
+	return collisions
+}
+
+// Remove removes the item at the index, if there is one.
+func (s *Store) Remove(idx int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remove(idx)
+}
+
+func (s *Store) remove(idx int) {
+	if idx < 0 || idx >= len(s.items) {
+		return
+	}
+	s.size -= s.items[idx].Size()
+	s.items = slices.Delete(s.items, idx, idx+1)
+	s.change()
+	s.reindex(false)
+}
+
+// Update replaces the data of the item at idx and recomputes its hash. If the
+// new data collides with another item, that other item is removed and its pins
+// and tags are merged into the item, unless Config.AllowDuplicates is set. Data
+// exceeding the size limits is refused, see CheckSize.
+func (s *Store) Update(idx int, data string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if idx < 0 || idx >= len(s.items) {
+		return nil
+	}
+
+	item := s.items[idx]
+	if err := s.CheckSize(data, item.Binary); err != nil {
+		return err
+	}
+	hash := s.hash(data, item.Binary)
+	s.size -= item.Size()
+	item.Data = data
+	s.size += item.Size()
+	item.Hash = hash
+	s.change()
+	if other, exists := s.index[hash]; exists && other != idx && !s.config.AllowDuplicates {
+		item.Pinned = item.Pinned || s.items[other].Pinned
+		item.Tag(s.items[other].Tags...)
+		s.remove(other)
+	}
+	s.reindex(false)
+	return nil
+}
+
+// Dedup rehashes every item and collapses the ones with equal hashes into the
+// most recently added or pasted one, which keeps the pins and tags of its
+// duplicates. It returns how many duplicates were removed.
+func (s *Store) Dedup() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.dedup()
+}
+
+func (s *Store) dedup() int {
+	s.rehash()
+	return s.reindex(true)
+}
+
+// Import merges the items of an export into the history. Both histories keep
+// their own order and are interleaved by how recently each item was added or
+// pasted, items that exist in both end up at the more recent position. The
+// history is left untouched if the export is invalid. Expired items are
+// dropped and the oldest items evicted like when adding. It returns how many
+// items were added.
+func (s *Store) Import(data []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var f file
+	if err := decode(bytes.NewReader(data), &f, s.config.Key); err != nil {
+		return 0, fmt.Errorf("invalid export: %w", err)
+	}
+	if f.Version > schemaVersion {
+		return 0, fmt.Errorf("export version %d is newer than the supported version %d", f.Version, schemaVersion)
+	}
+	if err := f.validate(); err != nil {
+		return 0, fmt.Errorf("invalid export: %w", err)
+	}
+	// Migrations only touch the items, so run them on a throwaway store
+	imported := &Store{config: s.config, version: f.Version, items: f.Items}
+	imported.migrate()
+
+	n := len(s.items)
+	merged := make([]*Item, 0, len(s.items)+len(imported.items))
+	current, other := s.items, imported.items
+	for len(current) > 0 && len(other) > 0 {
+		if other[0].recency() < current[0].recency() {
+			merged = append(merged, other[0])
+			other = other[1:]
+		} else {
+			merged = append(merged, current[0])
+			current = current[1:]
+		}
+	}
+	merged = append(append(merged, current...), other...)
+
+	s.items = merged
+	s.change()
+	s.expire()
+	s.dedup()
+	added := len(s.items) - n
+	// Like adding, the history is then capped by evicting the oldest items
+	s.measure()
+	s.evict()
+	return added, nil
+}
+
+// FindHash returns the index of the item with the hash, or with the only hash
+// starting with it.
+func (s *Store) FindHash(hash string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if hash == "" {
+		return 0, fmt.Errorf("no hash provided")
+	}
+	if idx, exists := s.index[hash]; exists {
+		return idx, nil
+	}
+
+	found := -1
+	for i, item := range s.items {
+		if !strings.HasPrefix(item.Hash, hash) {
+			continue
+		}
+		if found >= 0 {
+			return 0, fmt.Errorf("ambiguous hash prefix: %s", hash)
+		}
+		found = i
+	}
+	if found < 0 {
+		return 0, fmt.Errorf("no such hash: %s", hash)
+	}
+	return found, nil
+}
+
+// Move relocates the item at from to to, shifting the items in between,
+// without touching its data or timestamps.
+func (s *Store) Move(from, to int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if from == to || from < 0 || from >= len(s.items) || to < 0 || to >= len(s.items) {
+		return
+	}
+
+	item := s.items[from]
+	s.items = slices.Insert(slices.Delete(s.items, from, from+1), to, item)
+	s.change()
+	s.reindex(false)
+}
+
+// Promote moves the item at idx to the end of the list, making it the latest
+// item while keeping its metadata intact.
+func (s *Store) Promote(idx int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.promote(idx)
+}
+
+func (s *Store) promote(idx int) {
+	if idx < 0 || idx >= len(s.items)-1 {
+		return
+	}
+
+	item := s.items[idx]
+	s.items = append(slices.Delete(s.items, idx, idx+1), item)
+	s.change()
+	s.reindex(false)
+}
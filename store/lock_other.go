@@ -0,0 +1,18 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package store
+
+import (
+	"os"
+	"time"
+)
+
+// lockFile is a no-op on platforms without flock; concurrent invocations are
+// not serialized there.
+func lockFile(file *os.File, timeout time.Duration) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
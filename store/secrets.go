@@ -0,0 +1,27 @@
+package store
+
+import "regexp"
+
+// secretPatterns match common credentials, items matching any of them are
+// marked sensitive when Config.DetectSecrets is enabled.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`),                            // AWS access key ID
+	regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`),                         // GitHub token
+	regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{40,}\b`),                       // GitHub fine-grained token
+	regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`),                         // Slack token
+	regexp.MustCompile(`\b[sr]k_(live|test)_[A-Za-z0-9]{16,}\b`),                 // Stripe key
+	regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}`),                                // OpenAI style API key
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`),                     // PEM private key
+	regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.`),         // JWT
+	regexp.MustCompile(`(?i)\baws_secret_access_key\s*[=:]\s*[A-Za-z0-9/+]{40}`), // AWS secret key
+}
+
+// looksSecret reports whether the data contains a credential.
+func looksSecret(data string) bool {
+	for _, pattern := range secretPatterns {
+		if pattern.MatchString(data) {
+			return true
+		}
+	}
+	return false
+}
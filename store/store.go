@@ -0,0 +1,495 @@
+// Package store keeps the clipboard history of clip in a data file. A Store
+// holds an advisory lock on the file from Open until Close, so concurrent
+// processes serialize. Its methods lock the store, but the items they return
+// are shared with it, so goroutines changing items directly, and calling
+// Touch after, must synchronize with every other use of the store.
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lockTimeout is how long to wait for another process to release the lock.
+const lockTimeout = 2 * time.Second
+
+// ErrLockTimeout is returned by Open when another process holds the lock.
+var ErrLockTimeout = errors.New("timed out waiting for lock")
+
+// ErrReadOnly is returned when changing a history that was opened read-only,
+// see ReadOnly.
+var ErrReadOnly = errors.New("the data file is not writable, changes can't be saved")
+
+// Logger is told about problems the store recovers from, and what it is
+// doing. A nil Logger discards both.
+type Logger interface {
+	Warnf(format string, args ...any)
+	Debugf(format string, args ...any)
+}
+
+// Config configures how the history is stored and deduplicated. The zero
+// value keeps every item verbatim, forever.
+type Config struct {
+	// MaxItems caps the number of items, evicting the oldest unpinned ones
+	// first. 0 means no limit.
+	MaxItems int
+	// MaxItemBytes caps the size of a single item, see CheckSize. 0 means no
+	// limit.
+	MaxItemBytes int
+	// MaxTotalBytes caps the combined size of the items, evicting the oldest
+	// unpinned ones first. 0 means no limit.
+	MaxTotalBytes int
+	// TTL expires items older than it when loading the history. 0 means items
+	// never expire.
+	TTL time.Duration
+	// DetectSecrets marks items that look like credentials as sensitive.
+	DetectSecrets bool
+	// SensitiveTTL expires sensitive items older than it when loading the
+	// history. 0 means they expire like any other item.
+	SensitiveTTL time.Duration
+	// CaseInsensitive ignores case when deduplicating items.
+	CaseInsensitive bool
+	// Trim ignores leading and trailing whitespace when deduplicating items,
+	// otherwise items differing only in whitespace are kept apart verbatim.
+	Trim bool
+	// AllowDuplicates keeps re-added data as a new item instead of moving the
+	// existing one to the end, so the history is a timeline of every addition.
+	// Hashes then refer to their latest item.
+	AllowDuplicates bool
+	// NormalizeNewlines treats CRLF line endings as LF when deduplicating
+	// items, so text copied on Windows matches its Unix equivalent.
+	NormalizeNewlines bool
+	// Compress writes the data file gzip compressed, reading detects it either
+	// way.
+	Compress bool
+	// Key is the passphrase the data file is encrypted with, if set.
+	Key string
+	// FileMode is the permissions the data file is written with, 0644 if
+	// unset.
+	FileMode os.FileMode
+	// DirMode is the permissions the data directory is created with, 0755 if
+	// unset.
+	DirMode os.FileMode
+	// Logger receives warnings and debug lines, if set.
+	Logger Logger
+}
+
+// Store is the clipboard history, ordered from the oldest to the latest item.
+// Indices passed to and returned by its methods are positions in that order.
+type Store struct {
+	mu       sync.Mutex
+	config   Config
+	filePath string
+	lock     *os.File
+	version  int
+	items    []*Item
+	index    map[string]int
+	size     int  // Combined size of the items, kept up to date as they change
+	dirty    bool // Whether the items changed since they were loaded
+	revision int  // Number of changes since Open, see Revision
+	readOnly bool // Whether the data file can't be written, see ReadOnly
+}
+
+// file is the format of the data file.
+type file struct {
+	Version int     `json:"v,omitempty"` // Version of the data file format
+	Items   []*Item `json:"i,omitempty"`
+}
+
+// validate fails if an item of the file is missing or has no data, which
+// would otherwise only fail once it is used.
+func (f *file) validate() error {
+	for i, item := range f.Items {
+		if item == nil || strings.TrimSpace(item.Data) == "" {
+			return fmt.Errorf("item %d has no data", i)
+		}
+	}
+	return nil
+}
+
+// schemaVersion is the current version of the data file format, files without
+// a version are version 0.
+const schemaVersion = 1
+
+// migrations upgrade the data file format, migrations[n] upgrades a file from
+// version n to n+1.
+var migrations = [schemaVersion]func(s *Store){
+	// 0 -> 1: Hashes moved from SHA-1 to SHA-256
+	func(s *Store) { s.rehash() },
+}
+
+// Open loads the history from the data file at the path, creating the file
+// and its directory if they don't exist, and locks it until Close. A corrupt
+// data file is moved aside and the history starts over empty. If the data file
+// can't be written, e.g. on a read-only mount, the history is opened read-only
+// and unlocked instead.
+func Open(path string, config Config) (*Store, error) {
+	if config.FileMode == 0 {
+		config.FileMode = 0o644
+	}
+	if config.DirMode == 0 {
+		config.DirMode = 0o755
+	}
+	s := &Store{config: config, filePath: path}
+
+	dir := filepath.Dir(path)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		// Create the directory if it does not exist
+		if err := os.MkdirAll(dir, config.DirMode); err != nil {
+			return nil, fmt.Errorf("error creating directory: %w", err)
+		}
+	}
+
+	// Hold an advisory lock until Close so concurrent invocations serialize
+	// instead of overwriting each other's changes. The lock lives in a sibling
+	// file since the data file itself is replaced on every write.
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, config.FileMode)
+	if notWritable(err) {
+		s.warnf("The data directory is not writable, changes won't be saved: %v", err)
+		s.readOnly = true
+	} else if err != nil {
+		return nil, fmt.Errorf("error opening lock file: %w", err)
+	} else if err := lockFile(lock, lockTimeout); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("error locking data file, is another clip running? %w", err)
+	} else {
+		s.lock = lock
+	}
+
+	if err := s.read(); err != nil {
+		s.unlock()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload discards the items in memory and reads the data file again, e.g.
+// after it was edited by hand while the store held the lock. Like Open, a
+// corrupt data file is moved aside and the history starts over empty.
+func (s *Store) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.version, s.items, s.dirty = 0, nil, false
+	return s.read()
+}
+
+// read loads the data file and brings it up to date.
+func (s *Store) read() error {
+	if err := s.load(); err != nil {
+		return err
+	}
+	if s.version > schemaVersion {
+		return fmt.Errorf("data file version %d is newer than the supported version %d, please upgrade clip", s.version, schemaVersion)
+	}
+	s.migrate()
+	s.expire()
+	s.rehash()
+	if n := s.reindex(false); n > 0 && !s.config.AllowDuplicates {
+		// NOTE: Not collapsed here, since items can collide after a settings
+		// change, which Dedup is for
+		s.debugf("Found %s sharing a hash with a later item, dedup to collapse them", Plural(n, "item"))
+	}
+	s.measure()
+	s.debugf("Loaded %s", Plural(len(s.items), "item"))
+	return nil
+}
+
+// load reads the data file, a freshly created (empty) file is an empty
+// history.
+func (s *Store) load() error {
+	flag := os.O_RDWR | os.O_CREATE
+	if s.readOnly {
+		flag = os.O_RDONLY
+	}
+	f, err := os.OpenFile(s.filePath, flag, s.config.FileMode)
+	if notWritable(err) && !s.readOnly {
+		s.warnf("The data file is not writable, changes won't be saved: %v", err)
+		s.readOnly = true
+		f, err = os.Open(s.filePath)
+	}
+	if s.readOnly && errors.Is(err, os.ErrNotExist) {
+		return nil // Nothing to read, and nothing can be written
+	} else if err != nil {
+		return fmt.Errorf("error opening file: %w", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			s.warnf("Failed to close file: %v", err)
+		}
+	}()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("error stating file: %w", err)
+	}
+	if info.Size() == 0 {
+		return nil
+	}
+
+	var data file
+	err = decode(f, &data, s.config.Key)
+	if err == nil {
+		err = data.validate()
+	}
+	if errors.Is(err, ErrNoKey) || errors.Is(err, ErrBadKey) {
+		// Not being able to decrypt the file does not make it corrupt
+		return err
+	} else if err != nil && !errors.Is(err, io.EOF) {
+		// Keep the corrupt file around and start over with an empty history
+		// so clip stays usable, the next Close writes a fresh file
+		corruptPath := s.filePath + ".corrupt-" + time.Now().Format("20060102150405")
+		s.warnf("Failed to load the data file, moving it to %s and starting with an empty history: %v", corruptPath, err)
+		if err := os.Rename(s.filePath, corruptPath); err != nil {
+			return fmt.Errorf("error moving corrupt file: %w", err)
+		}
+		return nil
+	}
+	s.version, s.items = data.Version, data.Items
+	return nil
+}
+
+// undoSuffix names the sidecar file holding the state before the last change.
+const undoSuffix = ".undo"
+
+// ErrNoUndo is returned by Undo when there is no previous state to restore.
+var ErrNoUndo = errors.New("nothing to undo")
+
+// Undo swaps the data file with the state before the last change, so undoing
+// again redoes it. The in memory state is discarded and read again from the
+// restored data file.
+func (s *Store) Undo() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	undoPath := s.filePath + undoSuffix
+	if _, err := os.Stat(undoPath); errors.Is(err, os.ErrNotExist) {
+		return ErrNoUndo
+	}
+
+	swapPath := s.filePath + ".swap"
+	if err := os.Rename(s.filePath, swapPath); err != nil {
+		return fmt.Errorf("error swapping undo file: %w", err)
+	}
+	if err := os.Rename(undoPath, s.filePath); err != nil {
+		// Put the current state back
+		if restoreErr := os.Rename(swapPath, s.filePath); restoreErr != nil {
+			err = errors.Join(err, fmt.Errorf("error restoring data file: %w", restoreErr))
+		}
+		return fmt.Errorf("error swapping undo file: %w", err)
+	}
+	if err := os.Rename(swapPath, undoPath); err != nil {
+		return fmt.Errorf("error swapping undo file: %w", err)
+	}
+
+	s.version, s.items, s.dirty = 0, nil, false
+	if err := s.read(); err != nil {
+		return err
+	}
+	// Upgrading the restored state is no change of its own, writing it would
+	// replace the state undoing again redoes
+	s.dirty = false
+	s.revision++
+	return nil
+}
+
+// Close persists the history if it changed and releases the lock. The data is
+// written to a temporary file in the same directory which is then renamed over
+// the data file, so an interrupted write never leaves a truncated history
+// behind.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.unlock()
+
+	// Nothing to write for read-only operations
+	if !s.dirty {
+		s.debugf("Nothing changed, leaving %s untouched", s.filePath)
+		return nil
+	}
+	if s.readOnly {
+		s.debugf("Not saving the changes to %s: %v", s.filePath, ErrReadOnly)
+		return nil
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(s.filePath), filepath.Base(s.filePath)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("error opening file for writing: %w", err)
+	}
+
+	tmpPath := f.Name()
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		if err := f.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+			s.warnf("Failed to close file: %v", err)
+		}
+		if err := os.Remove(tmpPath); err != nil {
+			s.warnf("Failed to remove temporary file: %v", err)
+		}
+	}()
+
+	// The temporary file is private until now, so the data is never readable
+	// with wider permissions than configured
+	if err := f.Chmod(s.config.FileMode); err != nil {
+		return fmt.Errorf("error setting file permissions: %w", err)
+	}
+
+	if err := s.encode(f); err != nil {
+		return fmt.Errorf("error encoding JSON: %w", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("error syncing file: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error closing file: %w", err)
+	}
+
+	// Keep the previous state around for Undo, hard linked so the data file
+	// is replaced atomically below. Failing to do so only loses the undo.
+	undoPath := s.filePath + undoSuffix
+	if err := os.Remove(undoPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.warnf("Failed to remove undo file: %v", err)
+	} else if err := os.Link(s.filePath, undoPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.warnf("Failed to keep undo file: %v", err)
+	}
+
+	if err := os.Rename(tmpPath, s.filePath); err != nil {
+		return fmt.Errorf("error replacing data file: %w", err)
+	}
+	committed = true
+	s.debugf("Wrote %s to %s", Plural(len(s.items), "item"), s.filePath)
+
+	return nil
+}
+
+// Path returns the path of the data file.
+func (s *Store) Path() string {
+	return s.filePath
+}
+
+// ReadOnly reports whether the history was opened read-only, since the data
+// file can't be written. Changes are kept in memory, but Close doesn't write
+// them.
+func (s *Store) ReadOnly() bool {
+	return s.readOnly
+}
+
+// Export returns the whole history as JSON, in the format of the data file
+// without compression or encryption, to be read back by Import.
+func (s *Store) Export() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return json.Marshal(file{Version: s.version, Items: s.items})
+}
+
+// gzipMagic is the header of gzip compressed data.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decode reads the data file, which is decrypted and decompressed as needed
+// regardless of the Compress setting.
+func decode(r io.Reader, f *file, key string) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if encrypted(data) {
+		if data, err = decrypt(key, data); err != nil {
+			return err
+		}
+	}
+
+	var src io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(data, gzipMagic) {
+		gz, err := gzip.NewReader(src)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		src = gz
+	}
+	return json.NewDecoder(src).Decode(f)
+}
+
+// encode writes the data file, compressing and encrypting it if configured.
+func (s *Store) encode(w io.Writer) error {
+	f := file{Version: s.version, Items: s.items}
+
+	var buf bytes.Buffer
+	if s.config.Compress {
+		gz := gzip.NewWriter(&buf)
+		if err := json.NewEncoder(gz).Encode(f); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	} else if err := json.NewEncoder(&buf).Encode(f); err != nil {
+		return err
+	}
+
+	data := buf.Bytes()
+	if s.config.Key != "" {
+		var err error
+		if data, err = encrypt(s.config.Key, data); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(data)
+	return err
+}
+
+// unlock releases the lock taken in Open.
+func (s *Store) unlock() {
+	if s.lock == nil {
+		return
+	}
+	if err := unlockFile(s.lock); err != nil {
+		s.warnf("Failed to unlock data file: %v", err)
+	}
+	if err := s.lock.Close(); err != nil {
+		s.warnf("Failed to close lock file: %v", err)
+	}
+	s.lock = nil
+}
+
+func (s *Store) warnf(format string, args ...any) {
+	if s.config.Logger != nil {
+		s.config.Logger.Warnf(format, args...)
+	}
+}
+
+func (s *Store) debugf(format string, args ...any) {
+	if s.config.Logger != nil {
+		s.config.Logger.Debugf(format, args...)
+	}
+}
+
+// Plural formats the count with the noun, pluralized unless it is 1, e.g.
+// "1 item" or "2 items".
+func Plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,160 @@
+package store
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// open opens the store at the path, closing it when the test ends.
+func open(t *testing.T, path string, config Config) *Store {
+	t.Helper()
+	s, err := Open(path, config)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// data collects the data of the items, from the oldest to the latest.
+func data(s *Store) []string {
+	var out []string
+	for _, item := range s.List() {
+		out = append(out, item.Data)
+	}
+	return out
+}
+
+func TestAddMovesDuplicates(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "data.json"), Config{Trim: true})
+	for _, text := range []string{"a", "b", " a\n"} {
+		if _, err := s.Add(text); err != nil {
+			t.Fatalf("Add(%q): %v", text, err)
+		}
+	}
+	if got := data(s); !slices.Equal(got, []string{"b", " a\n"}) {
+		t.Fatalf("items = %q, want [b \" a\\n\"]", got)
+	}
+}
+
+func TestBinaryHashedApart(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "data.json"), Config{})
+	text := base64.StdEncoding.EncodeToString([]byte("hello"))
+	if _, err := s.Add(text); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddBinary([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 2 || s.Get(0).Binary || !s.Get(1).Binary {
+		t.Fatalf("items = %+v, %+v, want a text and a binary item", s.Get(0), s.Get(1))
+	}
+}
+
+func TestCloseWritesChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	s, err := Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Add("a")
+	s.Add("b")
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	s = open(t, path, Config{})
+	if got := data(s); !slices.Equal(got, []string{"a", "b"}) {
+		t.Fatalf("items = %q, want [a b]", got)
+	}
+}
+
+func TestUndo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	for _, text := range []string{"a", "b"} {
+		s, err := Open(path, Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Add(text)
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := open(t, path, Config{})
+	if err := s.Undo(); err != nil {
+		t.Fatalf("Undo: %v", err)
+	}
+	if got := data(s); !slices.Equal(got, []string{"a"}) {
+		t.Fatalf("items after undo = %q, want [a]", got)
+	}
+	if err := s.Undo(); err != nil {
+		t.Fatalf("Undo: %v", err)
+	}
+	if got := data(s); !slices.Equal(got, []string{"a", "b"}) {
+		t.Fatalf("items after redo = %q, want [a b]", got)
+	}
+}
+
+func TestRangeSnapshot(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "data.json"), Config{})
+	for _, text := range []string{"a", "b", "c"} {
+		s.Add(text)
+	}
+
+	// Removing while ranging neither skips nor repeats an item
+	var seen []string
+	for i, item := range s.Range {
+		seen = append(seen, item.Data)
+		if i == 2 {
+			s.Remove(1)
+		}
+	}
+	if !slices.Equal(seen, []string{"c", "b", "a"}) {
+		t.Fatalf("ranged over %q, want [c b a]", seen)
+	}
+	if got := data(s); !slices.Equal(got, []string{"a", "c"}) {
+		t.Fatalf("items = %q, want [a c]", got)
+	}
+}
+
+func TestOpenMovesInvalidItemsAside(t *testing.T) {
+	for _, content := range []string{`{"v":1,"i":[null]}`, `{"v":1,"i":[{"d":"a"},{"h":"x"}]}`} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "data.json")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		s := open(t, path, Config{})
+		if s.Len() != 0 {
+			t.Errorf("%s: Len = %d, want 0", content, s.Len())
+		}
+		if corrupt, _ := filepath.Glob(path + ".corrupt-*"); len(corrupt) != 1 {
+			t.Errorf("%s: moved aside to %q, want one corrupt file", content, corrupt)
+		}
+	}
+}
+
+func TestRevision(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "data.json"), Config{})
+	s.Add("a")
+	s.Add("b")
+	revision := s.Revision()
+
+	// Reads leave it alone, changes bump it even when discarded
+	s.Get(0)
+	s.List()
+	if s.Revision() != revision {
+		t.Fatalf("Revision after reading = %d, want %d", s.Revision(), revision)
+	}
+	s.Promote(0)
+	s.Discard()
+	if s.Revision() == revision {
+		t.Fatal("Revision unchanged after promoting")
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !plan9
+
+package store
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// notWritable reports whether the error is from opening a file for writing
+// where that is not allowed, including on a read-only file system.
+func notWritable(err error) bool {
+	return errors.Is(err, os.ErrPermission) || errors.Is(err, syscall.EROFS)
+}
@@ -0,0 +1,13 @@
+package store
+
+import (
+	"errors"
+	"os"
+)
+
+// notWritable reports whether the error is from opening a file for writing
+// where that is not allowed. Plan 9 has no EROFS, so only permission errors
+// are recognized.
+func notWritable(err error) bool {
+	return errors.Is(err, os.ErrPermission)
+}
@@ -0,0 +1,9 @@
+package main
+
+import "syscall"
+
+// The ioctl requests getting and setting the terminal attributes.
+const (
+	ioctlGetTermios = syscall.TCGETS
+	ioctlSetTermios = syscall.TCSETS
+)
@@ -0,0 +1,27 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// resizeSignals is empty, resizing is only picked up on the next key press.
+var resizeSignals []os.Signal
+
+// ttySize is not supported on this platform.
+func ttySize(file *os.File) (cols, rows int) {
+	return 0, 0
+}
+
+// ttyColumns is not supported on this platform, terminalWidth falls back to
+// $COLUMNS.
+func ttyColumns(file *os.File) int {
+	return 0
+}
+
+// makeRaw is not supported on this platform, --pick falls back to a prompt.
+func makeRaw(file *os.File) (func() error, error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
@@ -0,0 +1,65 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// resizeSignals are sent when the terminal is resized.
+var resizeSignals = []os.Signal{syscall.SIGWINCH}
+
+// ttySize asks the terminal behind the file for its size, returning zeros if
+// it is not a terminal.
+func ttySize(file *os.File) (cols, rows int) {
+	var ws struct{ Row, Col, Xpixel, Ypixel uint16 }
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 {
+		return 0, 0
+	}
+	return int(ws.Col), int(ws.Row)
+}
+
+// ttyColumns asks the terminal behind the file for its width, returning 0 if
+// it is not a terminal.
+func ttyColumns(file *os.File) int {
+	cols, _ := ttySize(file)
+	return cols
+}
+
+// makeRaw puts the terminal behind the file into raw mode, so every key press
+// is read as is and nothing is echoed. It returns a function restoring the
+// previous mode.
+func makeRaw(file *os.File) (func() error, error) {
+	var old syscall.Termios
+	if err := termios(file, ioctlGetTermios, &old); err != nil {
+		return nil, err
+	}
+
+	raw := old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := termios(file, ioctlSetTermios, &raw); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		return termios(file, ioctlSetTermios, &old)
+	}, nil
+}
+
+// termios gets or sets the terminal attributes of the file.
+func termios(file *os.File, request uintptr, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), request, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
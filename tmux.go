@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var errNoTmux = errors.New("not inside a tmux session, $TMUX is not set")
+
+// inTmux reports whether clip runs inside a tmux session.
+func inTmux() bool {
+	return os.Getenv("TMUX") != ""
+}
+
+// fromTmux returns the contents of the top tmux buffer.
+func fromTmux() (string, error) {
+	var stderr strings.Builder
+	cmd := exec.Command("tmux", "show-buffer")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("error running tmux: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// toTmux loads the data into a new tmux buffer.
+func toTmux(data string) error {
+	cmd := exec.Command("tmux", "load-buffer", "-")
+	cmd.Stdin = strings.NewReader(data)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("error running tmux: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}